@@ -0,0 +1,65 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const sensorChannelPrefix = "io.droidvirt.sensor."
+
+var sensorNameFormat = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+func addTouchInputDevices(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	if enabled, _ := strconv.ParseBool(annotations[touchscreenAnnotation]); !enabled {
+		return nil
+	}
+
+	exists := false
+	for _, input := range domainSpec.Devices.Inputs {
+		if input.Type == "tablet" && input.Bus == "virtio" {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		domainSpec.Devices.Inputs = append(domainSpec.Devices.Inputs, domainSchema.Input{
+			Type: "tablet",
+			Bus:  "virtio",
+		})
+	}
+
+	// libvirt has no multitouch input, qemu provides a virtio one since 8.0
+	if !hookutil.QEMUAtLeast(8, 0) {
+		log.Log.Info("Add virtio tablet device, multitouch needs QEMU 8.0 or later set by --qemu-version")
+		return nil
+	}
+	hookutil.AppendQEMUArgs(domainSpec, "-device", "virtio-multitouch-pci")
+	log.Log.Info("Add virtio tablet and multitouch devices")
+	return nil
+}
+
+func addSensorChannels(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	// sensor names split by comma, e.g. "gps,accelerometer"
+	sensorsStr, found := annotations[sensorChannelsAnnotation]
+	if !found {
+		return nil
+	}
+
+	var warnings hookutil.Warnings
+	for _, sensor := range strings.Split(sensorsStr, ",") {
+		sensor = strings.TrimSpace(sensor)
+		if !sensorNameFormat.MatchString(sensor) {
+			warnings.Addf("Invalid sensor name: %s", sensor)
+			continue
+		}
+		if err := addUnixChannel(domainSpec, sensorChannelPrefix+sensor); err != nil {
+			warnings.Addf("Failed to add channel of sensor %s: %v", sensor, err)
+		}
+	}
+	return warnings.Err()
+}
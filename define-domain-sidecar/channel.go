@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	"kubevirt.io/kubevirt/pkg/hooks"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const guestAgentChannel = "org.qemu.guest_agent.0"
+
+// the hooks directory is mounted into both the sidecar and the compute container
+var channelDirectory = filepath.Join(hooks.HookSocketsSharedDirectory, "channels")
+
+var channelNameFormat = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+func addChannels(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	var warnings hookutil.Warnings
+	if enabled, _ := strconv.ParseBool(annotations[guestAgentAnnotation]); enabled {
+		// libvirt connects to the agent itself, talk to it by the libvirt agent API
+		if err := addUnixChannel(domainSpec, guestAgentChannel); err != nil {
+			warnings.Addf("Failed to add guest agent channel: %v", err)
+		}
+	}
+
+	// channel names split by comma, e.g. "io.droidvirt.helper.0"
+	namesStr, found := annotations[channelNamesAnnotation]
+	if !found {
+		return warnings.Err()
+	}
+	for _, name := range strings.Split(namesStr, ",") {
+		name = strings.TrimSpace(name)
+		if !channelNameFormat.MatchString(name) {
+			warnings.Addf("Invalid channel name: %s", name)
+			continue
+		}
+		if err := addUnixChannel(domainSpec, name); err != nil {
+			warnings.Addf("Failed to add channel %s: %v", name, err)
+		}
+	}
+	return warnings.Err()
+}
+
+// addUnixChannel adds a virtio-serial channel whose unix socket is created by qemu in channelDirectory
+func addUnixChannel(domainSpec *domainSchema.DomainSpec, name string) error {
+	for _, channel := range domainSpec.Devices.Channels {
+		if channel.Target != nil && channel.Target.Name == name {
+			return nil
+		}
+	}
+
+	if !hookutil.DryRun {
+		if err := prepareChannelDirectory(); err != nil {
+			return err
+		}
+	}
+
+	socketPath := filepath.Join(channelDirectory, name+".sock")
+	domainSpec.Devices.Channels = append(domainSpec.Devices.Channels, domainSchema.Channel{
+		Type: "unix",
+		Source: domainSchema.ChannelSource{
+			Mode: "bind",
+			Path: socketPath,
+		},
+		Target: &domainSchema.ChannelTarget{
+			Type: "virtio",
+			Name: name,
+		},
+	})
+	log.Log.Infof("Add channel %s on socket %s", name, socketPath)
+	return nil
+}
+
+// prepareChannelDirectory lets qemu create the sockets, agents connecting to them need to run in the qemu group
+func prepareChannelDirectory() error {
+	if err := os.MkdirAll(channelDirectory, 0770); err != nil {
+		return err
+	}
+	if err := os.Chown(channelDirectory, hookutil.QEMUUID, hookutil.QEMUGID); err != nil {
+		return err
+	}
+	return os.Chmod(channelDirectory, 0770)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
 	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
 	"strconv"
 	"strings"
@@ -13,12 +14,11 @@ const (
 	defaultDiskDriver = "qcow2"
 )
 
-func convertVNCOptions(annotations map[string]string, domainSpec *domainSchema.DomainSpec) {
+func convertVNCOptions(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
 	if vncPortStr, found := annotations[vncPortAnnotation]; found {
 		vncPort, err := strconv.ParseInt(vncPortStr, 10, 32)
 		if err != nil || vncPort < 5900 {
-			log.Log.Errorf("Invalid VNC Port: %s", vncPortStr)
-			return
+			return hookutil.Warnf("Invalid VNC Port: %s", vncPortStr)
 		}
 
 		if wsPortStr, found := annotations[vncWebsocketPortAnnotation]; !found {
@@ -36,8 +36,7 @@ func convertVNCOptions(annotations map[string]string, domainSpec *domainSchema.D
 		} else {
 			wsPort, err := strconv.ParseInt(wsPortStr, 10, 32)
 			if err != nil || wsPort < 5900 || wsPort == vncPort {
-				log.Log.Errorf("Invalid WebSocket Port: %s", wsPortStr)
-				return
+				return hookutil.Warnf("Invalid WebSocket Port: %s", wsPortStr)
 			}
 
 			log.Log.Info("VNC WebSocket. Set options in XML 'qemu:commandline'")
@@ -62,9 +61,10 @@ func convertVNCOptions(annotations map[string]string, domainSpec *domainSchema.D
 			})
 		}
 	}
+	return nil
 }
 
-func convertDiskOptions(annotations map[string]string, domainSpec *domainSchema.DomainSpec) {
+func convertDiskOptions(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
 	// change data disk driver type: qcow2
 	if diskNames, found := annotations[diskNamesAnnotation]; found {
 		driverType := annotations[diskDriverAnnotation]
@@ -87,9 +87,10 @@ func convertDiskOptions(annotations map[string]string, domainSpec *domainSchema.
 			}
 		}
 	}
+	return nil
 }
 
-func addQEMUArgs(annotations map[string]string, domainSpec *domainSchema.DomainSpec) {
+func addQEMUArgs(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
 	if qemuArgs, found := annotations[qemuArgsAnnotation]; found {
 		args := []domainSchema.Arg{}
 		for _, arg := range strings.Split(qemuArgs, ";") {
@@ -106,4 +107,5 @@ func addQEMUArgs(annotations map[string]string, domainSpec *domainSchema.DomainS
 			domainSpec.QEMUCmd.QEMUArg = append(domainSpec.QEMUCmd.QEMUArg, args...)
 		}
 	}
+	return nil
 }
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+func convertFirmware(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	return hookutil.ConvertFirmware(annotations, hookutil.DefaultFirmware, domainSpec)
+}
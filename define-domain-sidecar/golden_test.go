@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil/golden"
+)
+
+// go test -run TestGolden -update rewrites expected.xml of every case.
+// Firmware files are looked up under the case directory.
+const goldenDirectory = "testdata/golden"
+
+var goldenHostDevAllowlist = []string{"0000:03:00.0", "046d:c52b"}
+
+func TestGolden(t *testing.T) {
+	golden.Run(t, goldenDirectory, func(dir string, hooksDir string) (hookutil.DomainDefiner, func()) {
+		channels, firmwareRoot := channelDirectory, hookutil.FirmwareRoot
+		channelDirectory = filepath.Join(hooksDir, "channels")
+		hookutil.FirmwareRoot = dir
+		return v1alpha1Server{hostDevAllowlist: goldenHostDevAllowlist}, func() {
+			channelDirectory, hookutil.FirmwareRoot = channels, firmwareRoot
+		}
+	})
+}
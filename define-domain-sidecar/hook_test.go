@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/tools/record"
 	"kubevirt.io/client-go/api/v1"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
 	hooksV1alpha1 "kubevirt.io/kubevirt/pkg/hooks/v1alpha1"
 	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
 )
@@ -168,3 +177,518 @@ func TestDefineDiskDriver(t *testing.T) {
 	}
 
 }
+
+func TestDefineDiskBus(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Disks: []domainSchema.Disk{
+				{
+					Device: "disk",
+					Type:   "file",
+					Target: domainSchema.DiskTarget{
+						Bus:    "virtio",
+						Device: "vda",
+					},
+					Model: "virtio-transitional",
+					Alias: &domainSchema.Alias{
+						Name: "test-disk",
+					},
+					Address: &domainSchema.Address{
+						Type: "pci",
+						Bus:  "0x05",
+					},
+				},
+				{
+					Device: "disk",
+					Type:   "file",
+					Target: domainSchema.DiskTarget{
+						Bus:    "sata",
+						Device: "sda",
+					},
+					Alias: &domainSchema.Alias{
+						Name: "other-disk",
+					},
+				},
+			},
+		},
+	}
+	domainSpecXML, err := xml.Marshal(domainSpec)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	vmi := new(v1.VirtualMachineInstance)
+	annotations := map[string]string{
+		hookutil.DiskBusAnnotation: "test-disk:scsi,other-disk:floppy",
+	}
+
+	vmi.SetAnnotations(annotations)
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+
+	ctx := context.TODO()
+
+	server := new(v1alpha1Server)
+	result, err := server.OnDefineDomain(ctx, &params)
+	if err != nil {
+		t.Errorf("Failed to invoke OnDefineDomain")
+	}
+
+	updateDomainSpec := domainSchema.DomainSpec{}
+	err = xml.Unmarshal(result.GetDomainXML(), &updateDomainSpec)
+	if err != nil {
+		t.Errorf("Failed to unmarshal the domain spec")
+	}
+
+	disk := updateDomainSpec.Devices.Disks[0]
+	if disk.Target.Bus != "scsi" || disk.Target.Device != "sdb" || disk.Address != nil || disk.Model != "" {
+		t.Errorf("Disk bus not change, %+v", disk)
+	}
+
+	if updateDomainSpec.Devices.Disks[1].Target.Bus != "sata" {
+		t.Errorf("Unsupported disk bus applied, %+v", updateDomainSpec.Devices.Disks[1])
+	}
+
+	if len(updateDomainSpec.Devices.Controllers) != 1 || updateDomainSpec.Devices.Controllers[0].Model != "virtio-scsi" {
+		t.Errorf("SCSI controller not added, %+v", updateDomainSpec.Devices.Controllers)
+	}
+}
+
+func TestDefineAndroidDevices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channels")
+	if err != nil {
+		t.Fatalf("Failed to create channel dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { channelDirectory = path }(channelDirectory)
+	defer func(uid, gid int) { hookutil.QEMUUID, hookutil.QEMUGID = uid, gid }(hookutil.QEMUUID, hookutil.QEMUGID)
+	channelDirectory = dir
+	hookutil.QEMUUID, hookutil.QEMUGID = os.Getuid(), os.Getgid()
+
+	domainSpec := domainSchema.DomainSpec{}
+	annotations := map[string]string{
+		touchscreenAnnotation:    "true",
+		sensorChannelsAnnotation: "gps, accelerometer,Bad/Name",
+	}
+	addTouchInputDevices(annotations, &domainSpec)
+	addSensorChannels(annotations, &domainSpec)
+
+	inputs := domainSpec.Devices.Inputs
+	if len(inputs) != 1 || inputs[0].Type != "tablet" || inputs[0].Bus != "virtio" {
+		t.Errorf("Unexpected inputs, %+v", inputs)
+	}
+
+	if domainSpec.QEMUCmd != nil {
+		t.Errorf("Multitouch device added without qemu version, %+v", domainSpec.QEMUCmd)
+	}
+
+	channels := domainSpec.Devices.Channels
+	if len(channels) != 2 || channels[0].Target.Name != "io.droidvirt.sensor.gps" ||
+		channels[1].Source.Path != dir+"/io.droidvirt.sensor.accelerometer.sock" {
+		t.Errorf("Unexpected channels, %+v", channels)
+	}
+
+	defer func(version string) { hookutil.QEMUVersion = version }(hookutil.QEMUVersion)
+	hookutil.QEMUVersion = "8.0.2"
+	domainSpec = domainSchema.DomainSpec{}
+	addTouchInputDevices(annotations, &domainSpec)
+	if len(domainSpec.Devices.Inputs) != 1 || domainSpec.QEMUCmd == nil || len(domainSpec.QEMUCmd.QEMUArg) != 2 ||
+		domainSpec.QEMUCmd.QEMUArg[1].Value != "virtio-multitouch-pci" {
+		t.Errorf("Multitouch device not added, %+v", domainSpec.QEMUCmd)
+	}
+}
+
+func TestDefineChannels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "channels")
+	if err != nil {
+		t.Fatalf("Failed to create channel dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { channelDirectory = path }(channelDirectory)
+	defer func(uid, gid int) { hookutil.QEMUUID, hookutil.QEMUGID = uid, gid }(hookutil.QEMUUID, hookutil.QEMUGID)
+	channelDirectory = dir + "/channels"
+	hookutil.QEMUUID, hookutil.QEMUGID = os.Getuid(), os.Getgid()
+
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Channels: []domainSchema.Channel{
+				{
+					Type: "unix",
+					Source: domainSchema.ChannelSource{
+						Mode: "bind",
+						Path: "/var/lib/libvirt/qemu/channel/target/domain-1/org.qemu.guest_agent.0",
+					},
+					Target: &domainSchema.ChannelTarget{
+						Type: "virtio",
+						Name: "org.qemu.guest_agent.0",
+					},
+				},
+			},
+		},
+	}
+	annotations := map[string]string{
+		guestAgentAnnotation:   "true",
+		channelNamesAnnotation: "io.droidvirt.helper.0,bad name",
+	}
+	addChannels(annotations, &domainSpec)
+
+	channels := domainSpec.Devices.Channels
+	if len(channels) != 2 || channels[1].Target.Name != "io.droidvirt.helper.0" ||
+		channels[1].Source.Path != channelDirectory+"/io.droidvirt.helper.0.sock" {
+		t.Errorf("Unexpected channels, %+v", channels)
+	}
+
+	info, err := os.Stat(channelDirectory)
+	if err != nil || info.Mode().Perm() != 0770 {
+		t.Fatalf("Channel directory not prepared")
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && (int(stat.Uid) != hookutil.QEMUUID || int(stat.Gid) != hookutil.QEMUGID) {
+		t.Errorf("Channel directory not owned by qemu, %d:%d", stat.Uid, stat.Gid)
+	}
+}
+
+func TestDefineHostDevices(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{}
+	annotations := map[string]string{
+		hostDevPCIAnnotation:     "03:00.0",
+		hostDevUSBAnnotation:     "046D:C52B",
+		hostDevManagedAnnotation: "true",
+		hostDevROMAnnotation:     "0000:03:00.0=off",
+	}
+	allowlist := []string{"0000:03:00.0", "046d:c52b"}
+	hostDevices := make(hostDeviceSet)
+	if err := addHostDevices(annotations, allowlist, hostDevices, &domainSpec); err != nil {
+		t.Fatalf("Failed to add host devices: %v", err)
+	}
+
+	placeholders := domainSpec.Devices.HostDevices
+	if len(placeholders) != 2 || placeholders[0].Alias.Name != "ua-hostdev-0000-03-00-0" || placeholders[1].Alias.Name != "ua-hostdev-usb-046d-c52b" {
+		t.Errorf("Unexpected host devices, %+v", placeholders)
+	}
+	if domainSpec.QEMUCmd != nil {
+		t.Errorf("Unexpected host device args, %+v", domainSpec.QEMUCmd)
+	}
+
+	domainXML, err := xml.Marshal(domainSpec)
+	if err != nil {
+		t.Fatalf("Failed to marshal domain: %v", err)
+	}
+	domainXML, err = hostDevices.expand(domainXML)
+	if err != nil {
+		t.Fatalf("Failed to expand host devices: %v", err)
+	}
+	pci := `<hostdev type="pci" managed="yes" mode="subsystem"><source><address type="pci" domain="0x0000" bus="0x03" slot="0x00" function="0x0"></address></source>` +
+		`<rom bar="off"></rom><alias name="ua-hostdev-0000-03-00-0"></alias></hostdev>`
+	usb := `<hostdev type="usb" mode="subsystem"><source><vendor id="0x046d"></vendor><product id="0xc52b"></product></source>` +
+		`<alias name="ua-hostdev-usb-046d-c52b"></alias></hostdev>`
+	if !strings.Contains(string(domainXML), pci+usb) {
+		t.Errorf("Unexpected host devices, %s", domainXML)
+	}
+
+	annotations = map[string]string{
+		hostDevPCIAnnotation: "0000:03:00.0,0000:04:00.0",
+	}
+	if err := addHostDevices(annotations, allowlist, make(hostDeviceSet), &domainSchema.DomainSpec{}); err == nil {
+		t.Errorf("Device not in allowlist is accepted")
+	}
+
+	// the short and the full address are the same device
+	domainSpec = domainSchema.DomainSpec{}
+	annotations = map[string]string{
+		hostDevPCIAnnotation: "03:00.0,0000:03:00.0",
+		hostDevUSBAnnotation: "046d:c52b,046D:C52B",
+	}
+	hostDevices = make(hostDeviceSet)
+	err = addHostDevices(annotations, allowlist, hostDevices, &domainSpec)
+	if warnings, ok := err.(hookutil.Warnings); !ok || len(warnings) != 2 ||
+		warnings[0] != "Duplicated PCI host device: 0000:03:00.0" || warnings[1] != "Duplicated USB host device: 046d:c52b" {
+		t.Errorf("Unexpected warnings: %v", err)
+	}
+	if len(domainSpec.Devices.HostDevices) != 2 || len(hostDevices) != 2 {
+		t.Errorf("Unexpected host devices, %+v", domainSpec.Devices.HostDevices)
+	}
+	domainXML, err = xml.Marshal(domainSpec)
+	if err != nil {
+		t.Fatalf("Failed to marshal domain: %v", err)
+	}
+	if domainXML, err = hostDevices.expand(domainXML); err != nil || strings.Count(string(domainXML), "<source>") != 2 {
+		t.Errorf("Unexpected host devices, %s, %v", domainXML, err)
+	}
+}
+
+func TestDefineMemoryBacking(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Ballooning: &domainSchema.Ballooning{
+				Model: "virtio",
+				Stats: &domainSchema.Stats{Period: 10},
+			},
+		},
+	}
+	annotations := map[string]string{
+		hugePagesAnnotation:    "2Mi",
+		memorySourceAnnotation: "memfd",
+		memoryLockedAnnotation: "true",
+		balloonAnnotation:      "none",
+	}
+	convertMemoryBacking(annotations, &domainSpec)
+
+	memoryBacking := domainSpec.MemoryBacking
+	if memoryBacking == nil || memoryBacking.HugePages == nil || memoryBacking.HugePages.HugePage[0].Size != "2048" ||
+		memoryBacking.Source == nil || memoryBacking.Source.Type != "memfd" ||
+		memoryBacking.Access == nil || memoryBacking.Access.Mode != "shared" || memoryBacking.NoSharePages != nil {
+		t.Errorf("Unexpected memory backing, %+v", memoryBacking)
+	}
+
+	if domainSpec.Devices.Ballooning.Model != "none" || domainSpec.Devices.Ballooning.Stats != nil {
+		t.Errorf("Unexpected memory balloon, %+v", domainSpec.Devices.Ballooning)
+	}
+
+	if domainSpec.QEMUCmd == nil || domainSpec.QEMUCmd.QEMUArg[1].Value != "mem-lock=on" {
+		t.Errorf("Memory not locked, %+v", domainSpec.QEMUCmd)
+	}
+
+	domainSpec = domainSchema.DomainSpec{}
+	convertMemoryBacking(map[string]string{hugePagesAnnotation: "2MB"}, &domainSpec)
+	if domainSpec.MemoryBacking != nil {
+		t.Errorf("Invalid hugepage size applied, %+v", domainSpec.MemoryBacking)
+	}
+}
+
+func TestDefineFirmware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvram")
+	if err != nil {
+		t.Fatalf("Failed to create nvram dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { hookutil.NVRamDirectory = path }(hookutil.NVRamDirectory)
+	defer func(uid, gid int) { hookutil.QEMUUID, hookutil.QEMUGID = uid, gid }(hookutil.QEMUUID, hookutil.QEMUGID)
+	hookutil.NVRamDirectory = dir + "/nvram"
+	hookutil.QEMUUID, hookutil.QEMUGID = os.Getuid(), os.Getgid()
+
+	template := dir + "/OVMF_VARS.fd"
+	if err := ioutil.WriteFile(template, []byte("vars"), 0644); err != nil {
+		t.Fatalf("Failed to create nvram template")
+	}
+
+	domainSpec := domainSchema.DomainSpec{
+		Name: "default_android",
+		OS: domainSchema.OS{
+			Type: domainSchema.OSType{OS: "hvm", Machine: "q35"},
+		},
+	}
+	annotations := map[string]string{
+		hookutil.FirmwareAnnotation:      "uefi",
+		hookutil.SecureBootAnnotation:    "true",
+		hookutil.NVRamTemplateAnnotation: template,
+		hookutil.MachineTypeAnnotation:   "pc-q35-4.2",
+	}
+	convertFirmware(annotations, &domainSpec)
+
+	if domainSpec.OS.Type.Machine != "pc-q35-4.2" {
+		t.Errorf("Unexpected machine type, %s", domainSpec.OS.Type.Machine)
+	}
+	loader := domainSpec.OS.BootLoader
+	if loader == nil || loader.Path != hookutil.DefaultUEFISecureLoader || loader.Secure != "yes" ||
+		domainSpec.Features == nil || domainSpec.Features.SMM == nil {
+		t.Errorf("Unexpected loader, %+v", loader)
+	}
+
+	nvram := domainSpec.OS.NVRam
+	if nvram == nil || nvram.Template != template || nvram.NVRam != hookutil.NVRamDirectory+"/default_android_VARS.fd" {
+		t.Fatalf("Unexpected nvram, %+v", nvram)
+	}
+	if content, err := ioutil.ReadFile(nvram.NVRam); err != nil || string(content) != "vars" {
+		t.Errorf("NVRam template not copied")
+	}
+
+	// the copy keeps the variables the guest wrote
+	ioutil.WriteFile(nvram.NVRam, []byte("written"), 0666)
+	convertFirmware(annotations, &domainSpec)
+	if content, _ := ioutil.ReadFile(nvram.NVRam); string(content) != "written" {
+		t.Errorf("NVRam overwritten by template")
+	}
+
+	// a storage volume which isn't mounted refuses the domain
+	annotations[hookutil.NVRamStorageAnnotation] = dir + "/missing"
+	err = convertFirmware(annotations, &domainSpec)
+	if _, warning := err.(hookutil.Warnings); err == nil || warning {
+		t.Errorf("Domain not refused without nvram storage, %v", err)
+	}
+
+	convertFirmware(map[string]string{hookutil.FirmwareAnnotation: "bios", hookutil.MachineTypeAnnotation: "virt"}, &domainSpec)
+	if domainSpec.OS.BootLoader != nil || domainSpec.OS.NVRam != nil || domainSpec.OS.Type.Machine != "pc-q35-4.2" {
+		t.Errorf("Unexpected bios firmware, %+v", domainSpec.OS)
+	}
+}
+
+func TestRenderDomain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatalf("Failed to create render dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { channelDirectory = path }(channelDirectory)
+	defer func(dryRun bool) { hookutil.DryRun = dryRun }(hookutil.DryRun)
+	channelDirectory = dir + "/channels"
+	hookutil.DryRun = true
+
+	domainXML := `<domain type="kvm">
+  <name>default_android</name>
+  <devices>
+    <interface type="bridge">
+      <alias name="ua-default"></alias>
+    </interface>
+  </devices>
+</domain>`
+	vmiYAML := `apiVersion: kubevirt.io/v1alpha3
+kind: VirtualMachineInstance
+metadata:
+  name: android
+  annotations:
+    nic.droidvirt.io/mtu: "ua-default:1400"
+    channel.droidvirt.io/names: io.droidvirt.helper.0
+`
+	ioutil.WriteFile(dir+"/domain.xml", []byte(domainXML), 0644)
+	ioutil.WriteFile(dir+"/vmi.yaml", []byte(vmiYAML), 0644)
+
+	out := &bytes.Buffer{}
+	if err := hookutil.RenderDomain(v1alpha1Server{}, dir+"/domain.xml", dir+"/vmi.yaml", true, out); err != nil {
+		t.Fatalf("Failed to render domain: %v", err)
+	}
+
+	diff := out.String()
+	if !strings.Contains(diff, "\n+      <mtu size=\"1400\"></mtu>\n") || strings.Contains(diff, "-    <interface") ||
+		!strings.Contains(diff, channelDirectory+"/io.droidvirt.helper.0.sock") {
+		t.Errorf("Unexpected diff, %s", diff)
+	}
+	if _, err := os.Stat(channelDirectory); !os.IsNotExist(err) {
+		t.Errorf("Channel directory created by render, %v", err)
+	}
+}
+
+func TestConversionRecord(t *testing.T) {
+	domainSpecXML := []byte(`<domain type="kvm"><name>default_android</name><devices><interface type="bridge"><alias name="ua-default"></alias></interface></devices></domain>`)
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(map[string]string{
+		hookutil.NICMTUAnnotation: "ua-default:1400",
+		balloonAnnotation:         "xen",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	server := new(v1alpha1Server)
+	result, err := server.OnDefineDomain(context.TODO(), &params)
+	if err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+
+	metadata := struct {
+		Record hookutil.ConversionRecord `xml:"metadata>droidvirt"`
+	}{}
+	if err := xml.Unmarshal(result.GetDomainXML(), &metadata); err != nil {
+		t.Fatalf("Failed to unmarshal the domain metadata: %v", err)
+	}
+	// the balloon is rejected and memory changes nothing else
+	record := metadata.Record
+	if record.Sidecar != hookName || len(record.Converters) != 1 || record.Converters[0] != "nic-options" ||
+		len(record.Warnings) != 1 || record.Warnings[0] != "Unsupported memory balloon: xen" {
+		t.Errorf("Unexpected conversion record, %+v", record)
+	}
+}
+
+type fakeVMIClient struct {
+	patches chan string
+}
+
+func (c fakeVMIClient) PatchVMI(namespace string, name string, patch []byte) error {
+	c.patches <- namespace + "/" + name + " " + string(patch)
+	return nil
+}
+
+func TestPublishResults(t *testing.T) {
+	client := fakeVMIClient{patches: make(chan string, 1)}
+	recorder := record.NewFakeRecorder(10)
+	server := v1alpha1Server{publisher: hookutil.NewResultPublisher(client, recorder)}
+
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.Name = "android"
+	vmi.Namespace = "default"
+	vmi.SetAnnotations(map[string]string{
+		vncPortAnnotation:          "5901",
+		vncWebsocketPortAnnotation: "5911",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	params := hooksV1alpha1.OnDefineDomainParams{[]byte(`<domain type="kvm"><name>default_android</name></domain>`), vmiJSON}
+	if _, err := server.OnDefineDomain(context.TODO(), &params); err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+
+	// published in the background
+	select {
+	case event := <-recorder.Events:
+		if event != "Normal Converted Applied converters: vnc" {
+			t.Errorf("Unexpected event, %s", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No event published")
+	}
+	select {
+	case patch := <-client.patches:
+		if !strings.HasPrefix(patch, "default/android ") || !strings.Contains(patch, hookutil.GraphicsStatusAnnotation) {
+			t.Errorf("Unexpected VMI patch, %s", patch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No VMI patch published")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	successes := testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues("vnc", hookutil.ResultSuccess))
+	rejected := testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues("memory", hookutil.ResultFailure))
+	refused := testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues("hostdev", hookutil.ResultFailure))
+	annotationErrors := testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckAnnotation))
+	refusedErrors := testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckRefused))
+	domainSpecXML, err := xml.Marshal(domainSchema.DomainSpec{})
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(map[string]string{
+		balloonAnnotation:    "xen",
+		hostDevPCIAnnotation: "0000:03:00.0",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	server := new(v1alpha1Server)
+	if _, err := server.OnDefineDomain(context.TODO(), &params); err == nil {
+		t.Fatalf("Host device not refused")
+	}
+
+	// the rejected balloon fails the memory converter without refusing the domain
+	if testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues("vnc", hookutil.ResultSuccess)) != successes+1 ||
+		testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues("memory", hookutil.ResultFailure)) != rejected+1 ||
+		testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues("hostdev", hookutil.ResultFailure)) != refused+1 {
+		t.Errorf("Converter runs not counted")
+	}
+	if testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckAnnotation)) != annotationErrors+1 ||
+		testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckRefused)) != refusedErrors+1 {
+		t.Errorf("Validation errors not counted")
+	}
+	if testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues("hostdev")) != 0 {
+		t.Errorf("Converter name used as check")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+var (
+	// domain:bus:slot.function, domain may be omitted
+	pciAddressFormat = regexp.MustCompile(`^(?:([0-9a-f]{4}):)?([0-9a-f]{2}):([0-9a-f]{2})\.([0-7])$`)
+	usbIDFormat      = regexp.MustCompile(`^([0-9a-f]{4}):([0-9a-f]{4})$`)
+)
+
+// hostDevice is a libvirt hostdev with the usb source and rom elements the domain schema lacks
+type hostDevice struct {
+	XMLName xml.Name            `xml:"hostdev"`
+	Type    string              `xml:"type,attr"`
+	Managed string              `xml:"managed,attr,omitempty"`
+	Mode    string              `xml:"mode,attr"`
+	Source  hostDeviceSource    `xml:"source"`
+	ROM     *hostDeviceROM      `xml:"rom,omitempty"`
+	Alias   *domainSchema.Alias `xml:"alias"`
+}
+
+type hostDeviceSource struct {
+	Vendor  *hostDeviceID         `xml:"vendor,omitempty"`
+	Product *hostDeviceID         `xml:"product,omitempty"`
+	Address *domainSchema.Address `xml:"address,omitempty"`
+}
+
+type hostDeviceID struct {
+	ID string `xml:"id,attr"`
+}
+
+type hostDeviceROM struct {
+	Bar  string `xml:"bar,attr,omitempty"`
+	File string `xml:"file,attr,omitempty"`
+}
+
+// hostDeviceSet keeps the host devices by alias until the domain is marshalled
+type hostDeviceSet map[string]*hostDevice
+
+// add puts a placeholder of the device into the domain, expand replaces it with the full element.
+// It returns false for a device already in the set, which would leave a second placeholder behind
+func (set hostDeviceSet) add(device *hostDevice, domainSpec *domainSchema.DomainSpec) bool {
+	if _, found := set[device.Alias.Name]; found {
+		return false
+	}
+	set[device.Alias.Name] = device
+	domainSpec.Devices.HostDevices = append(domainSpec.Devices.HostDevices, domainSchema.HostDevice{
+		Type:    device.Type,
+		Mode:    device.Mode,
+		Managed: device.Managed,
+		Alias:   device.Alias,
+	})
+	return true
+}
+
+// expand replaces the placeholders in the marshalled domain with the full hostdev elements
+func (set hostDeviceSet) expand(domainXML []byte) ([]byte, error) {
+	for alias, device := range set {
+		element, err := xml.Marshal(device)
+		if err != nil {
+			return nil, err
+		}
+		// aliases are made of hex digits and dashes, nothing to escape
+		idx := bytes.Index(domainXML, []byte(fmt.Sprintf(`<alias name="%s"></alias>`, alias)))
+		if idx < 0 {
+			return nil, fmt.Errorf("host device %s not found in domain", alias)
+		}
+		start := bytes.LastIndex(domainXML[:idx], []byte("<hostdev "))
+		end := bytes.Index(domainXML[idx:], []byte("</hostdev>"))
+		if start < 0 || end < 0 {
+			return nil, fmt.Errorf("host device %s not found in domain", alias)
+		}
+		end += idx + len("</hostdev>")
+
+		result := make([]byte, 0, len(domainXML)+len(element))
+		result = append(result, domainXML[:start]...)
+		result = append(result, element...)
+		domainXML = append(result, domainXML[end:]...)
+	}
+	return domainXML, nil
+}
+
+func addHostDevices(annotations map[string]string, allowlist []string, hostDevices hostDeviceSet, domainSpec *domainSchema.DomainSpec) error {
+	allowed := make(map[string]bool)
+	for _, device := range allowlist {
+		allowed[normalizeHostDevice(device)] = true
+	}
+
+	managed := "no"
+	if enabled, _ := strconv.ParseBool(annotations[hostDevManagedAnnotation]); enabled {
+		managed = "yes"
+	}
+
+	// address=value pairs split by comma, value is "off" or a rom file
+	roms := make(map[string]string)
+	if romsStr, found := annotations[hostDevROMAnnotation]; found {
+		for _, pair := range strings.Split(romsStr, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid host device rom: %s", pair)
+			}
+			roms[normalizeHostDevice(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	var warnings hookutil.Warnings
+	for _, device := range splitHostDevices(annotations[hostDevPCIAnnotation]) {
+		match := pciAddressFormat.FindStringSubmatch(device)
+		if match == nil {
+			return fmt.Errorf("invalid PCI address: %s", device)
+		}
+		if !allowed[device] {
+			return fmt.Errorf("PCI device %s is not in the host device allowlist", device)
+		}
+
+		hostDevice := &hostDevice{
+			Type:    "pci",
+			Mode:    "subsystem",
+			Managed: managed,
+			Source: hostDeviceSource{
+				Address: &domainSchema.Address{
+					Type:     "pci",
+					Domain:   "0x" + match[1],
+					Bus:      "0x" + match[2],
+					Slot:     "0x" + match[3],
+					Function: "0x" + match[4],
+				},
+			},
+			Alias: &domainSchema.Alias{
+				Name: "ua-hostdev-" + strings.NewReplacer(":", "-", ".", "-").Replace(device),
+			},
+		}
+		if rom, found := roms[device]; found {
+			if rom == "off" {
+				hostDevice.ROM = &hostDeviceROM{Bar: "off"}
+			} else if filepath.IsAbs(rom) {
+				hostDevice.ROM = &hostDeviceROM{File: rom}
+			} else {
+				return fmt.Errorf("invalid rom of PCI device %s: %s", device, rom)
+			}
+		}
+		if !hostDevices.add(hostDevice, domainSpec) {
+			warnings.Addf("Duplicated PCI host device: %s", device)
+			continue
+		}
+		log.Log.Infof("Add PCI host device %s", device)
+	}
+
+	for _, device := range splitHostDevices(annotations[hostDevUSBAnnotation]) {
+		match := usbIDFormat.FindStringSubmatch(device)
+		if match == nil {
+			return fmt.Errorf("invalid USB vendor:product: %s", device)
+		}
+		if !allowed[device] {
+			return fmt.Errorf("USB device %s is not in the host device allowlist", device)
+		}
+
+		// libvirt finds the device by vendor and product, the compute container needs its /dev/bus/usb node
+		hookutil.AddBusController("usb", domainSpec)
+		added := hostDevices.add(&hostDevice{
+			Type: "usb",
+			Mode: "subsystem",
+			Source: hostDeviceSource{
+				Vendor:  &hostDeviceID{ID: "0x" + match[1]},
+				Product: &hostDeviceID{ID: "0x" + match[2]},
+			},
+			Alias: &domainSchema.Alias{
+				Name: "ua-hostdev-usb-" + match[1] + "-" + match[2],
+			},
+		}, domainSpec)
+		if !added {
+			warnings.Addf("Duplicated USB host device: %s", device)
+			continue
+		}
+		log.Log.Infof("Add USB host device %s", device)
+	}
+	return warnings.Err()
+}
+
+func splitHostDevices(devicesStr string) []string {
+	devices := make([]string, 0)
+	for _, device := range strings.Split(devicesStr, ",") {
+		if device = normalizeHostDevice(device); device != "" {
+			devices = append(devices, device)
+		}
+	}
+	return devices
+}
+
+// normalizeHostDevice lowercases the device and adds the default domain to a short PCI address
+func normalizeHostDevice(device string) string {
+	device = strings.ToLower(strings.TrimSpace(device))
+	if match := pciAddressFormat.FindStringSubmatch(device); match != nil && match[1] == "" {
+		device = "0000:" + device
+	}
+	return device
+}
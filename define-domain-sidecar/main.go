@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"github.com/spf13/pflag"
 	"google.golang.org/grpc"
 	"net"
 	"os"
+	"strings"
 
 	vmSchema "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
 	"kubevirt.io/kubevirt/pkg/hooks"
 	hooksInfo "kubevirt.io/kubevirt/pkg/hooks/info"
 	hooksV1alpha1 "kubevirt.io/kubevirt/pkg/hooks/v1alpha1"
@@ -21,10 +24,32 @@ const (
 	vncWebsocketPortAnnotation = "websocket.vnc.droidvirt.io/port"
 	diskNamesAnnotation        = "disk.droidvirt.io/names" // split name by comma
 	diskDriverAnnotation       = "disk.droidvirt.io/driverType"
+	touchscreenAnnotation      = "input.droidvirt.io/touchscreen"
+	sensorChannelsAnnotation   = "sensor.droidvirt.io/channels" // split name by comma
+	guestAgentAnnotation       = "channel.droidvirt.io/guestAgent"
+	channelNamesAnnotation     = "channel.droidvirt.io/names" // split name by comma
+	hostDevPCIAnnotation       = "hostdev.droidvirt.io/pci"   // PCI addresses split by comma
+	hostDevUSBAnnotation       = "hostdev.droidvirt.io/usb"   // USB vendor:product split by comma
+	hostDevManagedAnnotation   = "hostdev.droidvirt.io/managed"
+	hostDevROMAnnotation       = "hostdev.droidvirt.io/rom"      // address=value pairs split by comma
+	hugePagesAnnotation        = "memory.droidvirt.io/hugepages" // page size, e.g. 2Mi or 1Gi
+	memoryLockedAnnotation     = "memory.droidvirt.io/locked"
+	noSharePagesAnnotation     = "memory.droidvirt.io/nosharepages"
+	memorySourceAnnotation     = "memory.droidvirt.io/source"  // memfd, file or anonymous
+	balloonAnnotation          = "memory.droidvirt.io/balloon" // virtio or none
 	qemuArgsAnnotation         = "qemu.droidvirt.io/args"
 	hookName                   = "droidvirt-define-domain"
+	hostDevAllowlistEnv        = "HOSTDEV_ALLOWLIST"
+	publishResultsEnv          = "PUBLISH_RESULTS"
+	metricsAddressEnv          = "METRICS_ADDRESS"
 )
 
+// version is set at build time, e.g. go build -ldflags "-X main.version=v0.2.0"
+var version = "dev"
+
+// annotations of these domains are the inputs of the converters
+var annotationDomains = []string{"droidvirt.io/"}
+
 type infoServer struct{}
 
 func (s infoServer) Info(ctx context.Context, params *hooksInfo.InfoParams) (*hooksInfo.InfoResult, error) {
@@ -44,14 +69,19 @@ func (s infoServer) Info(ctx context.Context, params *hooksInfo.InfoParams) (*ho
 	}, nil
 }
 
-type v1alpha1Server struct{}
+type v1alpha1Server struct {
+	// host devices VMs may take, PCI addresses or USB vendor:product
+	hostDevAllowlist []string
+	// nil unless conversion results are published to the VMI
+	publisher *hookutil.ResultPublisher
+}
 
-func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha1.OnDefineDomainParams) (*hooksV1alpha1.OnDefineDomainResult, error) {
+func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha1.OnDefineDomainParams) (result *hooksV1alpha1.OnDefineDomainResult, err error) {
 	log.Log.Info("Hook's OnDefineDomain callback method has been called")
 
 	vmiJSON := params.GetVmi()
 	vmiSpec := vmSchema.VirtualMachineInstance{}
-	err := json.Unmarshal(vmiJSON, &vmiSpec)
+	err = json.Unmarshal(vmiJSON, &vmiSpec)
 	if err != nil {
 		log.Log.Reason(err).Errorf("Failed to unmarshal given VMI spec: %s", vmiJSON)
 		panic(err)
@@ -67,13 +97,52 @@ func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha
 		panic(err)
 	}
 
-	convertVNCOptions(annotations, &domainSpec)
-	log.Log.Infof("after vnc convert: xmlns:%+v, %+v", domainSpec.XmlNS, domainSpec.QEMUCmd)
+	// the domain schema lacks parts of hostdev and interface drivers, they are filled in after marshalling
+	hostDevices := make(hostDeviceSet)
+	queueSizes := make(hookutil.InterfaceQueueSizes)
+	record := hookutil.NewConversionRecord(hookName, version, hookutil.InputsHash(annotations, annotationDomains, domainXML))
+	defer func() { s.publisher.Publish(&vmiSpec, record, &domainSpec, err) }()
+	convert := func(name string, converter func(map[string]string, *domainSchema.DomainSpec) error) error {
+		return record.Apply(name, &domainSpec, func() error {
+			return hookutil.ObserveConverter(name, func() error {
+				return converter(annotations, &domainSpec)
+			})
+		})
+	}
 
-	convertDiskOptions(annotations, &domainSpec)
-	log.Log.Infof("after disk convert: xmlns:%+v, %+v", domainSpec.XmlNS, domainSpec.QEMUCmd)
+	// in the order they are applied, hostdev refuses devices missing from the allowlist
+	converters := []struct {
+		name    string
+		convert func(map[string]string, *domainSchema.DomainSpec) error
+	}{
+		{"vnc", convertVNCOptions},
+		{"firmware", convertFirmware},
+		{"disk", convertDiskOptions},
+		{"disk-bus", hookutil.ConvertDiskBus},
+		{"nic-options", func(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+			return hookutil.ConvertInterfaceOptions(annotations, queueSizes, domainSpec)
+		}},
+		{"touch-input", addTouchInputDevices},
+		{"sensor-channels", addSensorChannels},
+		{"audio", hookutil.AddAudioDevice},
+		{"channels", addChannels},
+		{"memory", convertMemoryBacking},
+		{"hostdev", func(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+			return addHostDevices(annotations, s.hostDevAllowlist, hostDevices, domainSpec)
+		}},
+		{"qemu-args", addQEMUArgs},
+	}
+	for _, converter := range converters {
+		if err = convert(converter.name, converter.convert); err != nil {
+			return nil, err
+		}
+	}
 
-	addQEMUArgs(annotations, &domainSpec)
+	if err := hookutil.ValidateFirmware(annotations, nil, hookutil.FirmwareDigests{}, &domainSpec); err != nil {
+		log.Log.Reason(err).Error("Invalid firmware in updated domain spec")
+		hookutil.ValidationErrors.WithLabelValues(hookutil.CheckFirmware).Inc()
+		return nil, err
+	}
 
 	newDomainXML, err := xml.Marshal(domainSpec)
 	if err != nil {
@@ -81,6 +150,19 @@ func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha
 		panic(err)
 	}
 
+	newDomainXML = queueSizes.Expand(newDomainXML)
+	newDomainXML, err = hostDevices.expand(newDomainXML)
+	if err != nil {
+		log.Log.Reason(err).Error("Failed to add host devices to the domain")
+		return nil, err
+	}
+
+	newDomainXML, err = record.AddTo(newDomainXML)
+	if err != nil {
+		log.Log.Reason(err).Error("Failed to record conversions in domain metadata")
+		return nil, err
+	}
+
 	log.Log.Info("Successfully updated original domain spec with requested attributes")
 
 	return &hooksV1alpha1.OnDefineDomainResult{
@@ -94,6 +176,32 @@ func main() {
 	// hook) and a callback server (which does the heavy lifting).
 	log.InitializeLogging("droidvirt-hook-sidecar")
 
+	if len(os.Args) > 1 && os.Args[1] == hookutil.RenderCommand {
+		flags := pflag.NewFlagSet(hookutil.RenderCommand, pflag.ExitOnError)
+		hostDevAllowlist := flags.StringSlice("hostdev-allowlist", strings.Split(os.Getenv(hostDevAllowlistEnv), ","), "PCI addresses and USB vendor:product of host devices VMs may take")
+		os.Exit(hookutil.RunRender(os.Args[2:], flags, func() hookutil.DomainDefiner {
+			return v1alpha1Server{hostDevAllowlist: *hostDevAllowlist}
+		}))
+	}
+
+	hostDevAllowlist := pflag.StringSlice("hostdev-allowlist", strings.Split(os.Getenv(hostDevAllowlistEnv), ","), "PCI addresses and USB vendor:product of host devices VMs may take")
+	hookutil.AddQEMUVersionFlag(pflag.CommandLine)
+	publishResults := pflag.Bool("publish-results", os.Getenv(publishResultsEnv) == "true", "publish conversion results to the VMI by events and annotations")
+	metricsAddress := pflag.String("metrics-address", os.Getenv(metricsAddressEnv), "address to serve prometheus metrics on, e.g. :8443, empty to disable")
+	pflag.Parse()
+
+	if *metricsAddress != "" {
+		go hookutil.ServeMetrics(hookName, *metricsAddress)
+	}
+
+	var publisher *hookutil.ResultPublisher
+	if *publishResults {
+		var err error
+		if publisher, err = hookutil.NewInClusterResultPublisher(hookName); err != nil {
+			log.Log.Reason(err).Error("Failed to create KubeVirt client, conversion results are not published")
+		}
+	}
+
 	socketPath := hooks.HookSocketsSharedDirectory + "/" + hookName + ".sock"
 	socket, err := net.Listen("unix", socketPath)
 	if err != nil {
@@ -103,9 +211,12 @@ func main() {
 	}
 	defer os.Remove(socketPath)
 
-	server := grpc.NewServer([]grpc.ServerOption{}...)
+	server := grpc.NewServer(grpc.UnaryInterceptor(hookutil.MetricsInterceptor))
 	hooksInfo.RegisterInfoServer(server, infoServer{})
-	hooksV1alpha1.RegisterCallbacksServer(server, v1alpha1Server{})
+	hooksV1alpha1.RegisterCallbacksServer(server, v1alpha1Server{
+		hostDevAllowlist: *hostDevAllowlist,
+		publisher:        publisher,
+	})
 	log.Log.Infof("Starting hook server exposing 'info' and 'v1alpha1' services on socket %s", socketPath)
 	server.Serve(socket)
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+// hugepage sizes in KiB by suffix, e.g. "2Mi" or "1G"
+var hugePageUnits = map[string]uint64{
+	"K":  1,
+	"Ki": 1,
+	"M":  1024,
+	"Mi": 1024,
+	"G":  1024 * 1024,
+	"Gi": 1024 * 1024,
+}
+
+func convertMemoryBacking(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	var warnings hookutil.Warnings
+	memoryBacking := domainSpec.MemoryBacking
+	if memoryBacking == nil {
+		memoryBacking = &domainSchema.MemoryBacking{}
+	}
+
+	if sizeStr, found := annotations[hugePagesAnnotation]; found {
+		if size, ok := parseHugePageSize(sizeStr); !ok {
+			warnings.Addf("Invalid hugepage size: %s", sizeStr)
+		} else {
+			memoryBacking.HugePages = &domainSchema.HugePages{
+				HugePage: []domainSchema.HugePage{
+					{
+						Size: strconv.FormatUint(size, 10),
+						Unit: "KiB",
+					},
+				},
+			}
+		}
+	}
+
+	if enabled, _ := strconv.ParseBool(annotations[noSharePagesAnnotation]); enabled {
+		memoryBacking.NoSharePages = &domainSchema.NoSharePages{}
+	}
+
+	if source, found := annotations[memorySourceAnnotation]; found {
+		switch source {
+		case "memfd", "file":
+			// vhost-user backends map guest memory, so it has to be shared
+			memoryBacking.Source = &domainSchema.MemoryBackingSource{Type: source}
+			memoryBacking.Access = &domainSchema.MemoryBackingAccess{Mode: "shared"}
+		case "anonymous":
+			memoryBacking.Source = &domainSchema.MemoryBackingSource{Type: source}
+		default:
+			warnings.Addf("Unsupported memory source: %s", source)
+		}
+	}
+
+	if *memoryBacking != (domainSchema.MemoryBacking{}) {
+		domainSpec.MemoryBacking = memoryBacking
+	}
+
+	if enabled, _ := strconv.ParseBool(annotations[memoryLockedAnnotation]); enabled {
+		// domain schema has no locked memory backing, needs a memlock limit in the compute container
+		hookutil.AppendQEMUArgs(domainSpec, "-overcommit", "mem-lock=on")
+	}
+
+	if model, found := annotations[balloonAnnotation]; found {
+		if model != "virtio" && model != "none" {
+			warnings.Addf("Unsupported memory balloon: %s", model)
+		} else if domainSpec.Devices.Ballooning == nil {
+			domainSpec.Devices.Ballooning = &domainSchema.Ballooning{Model: model}
+		} else {
+			domainSpec.Devices.Ballooning.Model = model
+			if model == "none" {
+				domainSpec.Devices.Ballooning.Stats = nil
+			}
+		}
+	}
+	return warnings.Err()
+}
+
+// parseHugePageSize returns the hugepage size in KiB
+func parseHugePageSize(sizeStr string) (uint64, bool) {
+	sizeStr = strings.TrimSpace(sizeStr)
+	for _, suffix := range []string{"Ki", "Mi", "Gi", "K", "M", "G"} {
+		if strings.HasSuffix(sizeStr, suffix) {
+			size, err := strconv.ParseUint(strings.TrimSuffix(sizeStr, suffix), 10, 64)
+			if err != nil || size == 0 {
+				return 0, false
+			}
+			return size * hugePageUnits[suffix], true
+		}
+	}
+	return 0, false
+}
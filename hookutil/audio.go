@@ -0,0 +1,70 @@
+package hookutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const (
+	AudioModelAnnotation   = "audio.droidvirt.io/model"   // hda, ac97 or usb
+	AudioBackendAnnotation = "audio.droidvirt.io/backend" // none, spice or wav
+	AudioPathAnnotation    = "audio.droidvirt.io/path"    // wav output file
+)
+
+const (
+	audioDevID          = "audio0"
+	defaultAudioBackend = "none"
+)
+
+// domain schema has no sound device, so audio is set by qemu args
+var audioDevices = map[string][]string{
+	"hda":  {"-device", "ich9-intel-hda,id=sound0", "-device", "hda-duplex,audiodev=" + audioDevID},
+	"ac97": {"-device", "AC97,audiodev=" + audioDevID},
+	"usb":  {"-device", "usb-audio,audiodev=" + audioDevID},
+}
+
+// AddAudioDevice adds the sound card and audio backend the annotations ask for by qemu args
+func AddAudioDevice(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	model, found := annotations[AudioModelAnnotation]
+	if !found {
+		return nil
+	}
+	deviceArgs, found := audioDevices[model]
+	if !found {
+		return Warnf("Unsupported audio model: %s", model)
+	}
+
+	backend, found := annotations[AudioBackendAnnotation]
+	if !found {
+		backend = defaultAudioBackend
+	}
+	audiodev := ""
+	switch backend {
+	case "none", "spice":
+		// spice backend needs spice graphics on the domain
+		audiodev = fmt.Sprintf("%s,id=%s", backend, audioDevID)
+	case "wav":
+		path := annotations[AudioPathAnnotation]
+		if !filepath.IsAbs(path) {
+			return Warnf("Invalid wav output path: %s", path)
+		}
+		// commas in qemu option values are escaped by doubling them
+		audiodev = fmt.Sprintf("wav,id=%s,path=%s", audioDevID, strings.Replace(path, ",", ",,", -1))
+	default:
+		return Warnf("Unsupported audio backend: %s", backend)
+	}
+
+	if model == "usb" {
+		// usb audio needs a usb controller like usb disks
+		AddBusController("usb", domainSpec)
+	}
+
+	log.Log.Infof("Add %s audio device with %s backend", model, backend)
+	AppendQEMUArgs(domainSpec, "-audiodev", audiodev)
+	AppendQEMUArgs(domainSpec, deviceArgs...)
+	return nil
+}
@@ -0,0 +1,52 @@
+package hookutil
+
+import (
+	"testing"
+
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+func TestAudioDevice(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{}
+	annotations := map[string]string{
+		AudioModelAnnotation: "usb",
+	}
+	if err := AddAudioDevice(annotations, &domainSpec); err != nil {
+		t.Errorf("Failed to add audio device, %v", err)
+	}
+
+	args := domainSpec.QEMUCmd.QEMUArg
+	if len(args) != 4 || args[1].Value != "none,id=audio0" || args[3].Value != "usb-audio,audiodev=audio0" {
+		t.Errorf("Unexpected audio args, %+v", args)
+	}
+
+	controllers := domainSpec.Devices.Controllers
+	if len(controllers) != 1 || controllers[0].Type != "usb" || controllers[0].Model != "qemu-xhci" {
+		t.Errorf("Unexpected controllers, %+v", controllers)
+	}
+}
+
+func TestAudioDeviceWav(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{}
+	annotations := map[string]string{
+		AudioModelAnnotation:   "hda",
+		AudioBackendAnnotation: "wav",
+		AudioPathAnnotation:    "/var/run/audio/out,1.wav",
+	}
+	AddAudioDevice(annotations, &domainSpec)
+
+	if domainSpec.QEMUCmd == nil || len(domainSpec.QEMUCmd.QEMUArg) != 6 ||
+		domainSpec.QEMUCmd.QEMUArg[1].Value != "wav,id=audio0,path=/var/run/audio/out,,1.wav" ||
+		domainSpec.QEMUCmd.QEMUArg[5].Value != "hda-duplex,audiodev=audio0" {
+		t.Errorf("Unexpected audio args, %+v", domainSpec.QEMUCmd)
+	}
+
+	domainSpec = domainSchema.DomainSpec{}
+	annotations[AudioPathAnnotation] = "out.wav"
+	if _, ok := AddAudioDevice(annotations, &domainSpec).(Warnings); !ok {
+		t.Errorf("Relative wav path not rejected")
+	}
+	if domainSpec.QEMUCmd != nil {
+		t.Errorf("Audio added with relative wav path, %+v", domainSpec.QEMUCmd)
+	}
+}
@@ -0,0 +1,120 @@
+package hookutil
+
+import (
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const DiskBusAnnotation = "disk.droidvirt.io/bus" // name:bus pairs split by comma
+
+// disk target device prefix by bus
+var diskBusPrefix = map[string]string{
+	"virtio": "vd",
+	"sata":   "sd",
+	"scsi":   "sd",
+	"usb":    "sd",
+}
+
+// ConvertDiskBus moves disks to the target bus the annotation asks for, renaming their target device
+func ConvertDiskBus(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	// change disk target bus, e.g. "clover:sata,data-disk:sata"
+	busStr, found := annotations[DiskBusAnnotation]
+	if !found {
+		return nil
+	}
+
+	var warnings Warnings
+	buses := make(map[string]string)
+	for _, pair := range strings.Split(busStr, ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			warnings.Addf("Invalid disk bus: %s", pair)
+			continue
+		}
+		name, bus := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		if _, supported := diskBusPrefix[bus]; !supported {
+			warnings.Addf("Unsupported disk bus: %s", pair)
+			continue
+		}
+		buses[name] = bus
+	}
+
+	for idx, disk := range domainSpec.Devices.Disks {
+		if disk.Alias == nil {
+			continue
+		}
+		bus, found := buses[disk.Alias.Name]
+		if !found || disk.Target.Bus == bus {
+			continue
+		}
+
+		target := &domainSpec.Devices.Disks[idx]
+		target.Target.Bus = bus
+		if !strings.HasPrefix(target.Target.Device, diskBusPrefix[bus]) {
+			target.Target.Device = nextDiskDevice(diskBusPrefix[bus], domainSpec.Devices.Disks)
+		}
+		// address belongs to the old bus, libvirt assigns a new one
+		target.Address = nil
+		if bus != "virtio" {
+			// model, iothread and queues are virtio only
+			target.Model = ""
+			if target.Driver != nil {
+				target.Driver.IOThread = nil
+				target.Driver.Queues = nil
+			}
+		}
+		AddBusController(bus, domainSpec)
+		log.Log.Infof("Disk %s moved to bus %s as %s", disk.Alias.Name, bus, target.Target.Device)
+	}
+	return warnings.Err()
+}
+
+func nextDiskDevice(prefix string, disks []domainSchema.Disk) string {
+	used := make(map[string]bool)
+	for _, disk := range disks {
+		used[disk.Target.Device] = true
+	}
+	for idx := 0; ; idx++ {
+		// a..z, aa..az, ba.. like the kernel names them
+		suffix := ""
+		for n := idx; n >= 0; n = n/26 - 1 {
+			suffix = string(rune('a'+n%26)) + suffix
+		}
+		if !used[prefix+suffix] {
+			return prefix + suffix
+		}
+	}
+}
+
+// AddBusController adds the controller devices on a scsi or usb bus need, unless the domain has one
+func AddBusController(bus string, domainSpec *domainSchema.DomainSpec) {
+	switch bus {
+	case "scsi":
+		for _, ctrl := range domainSpec.Devices.Controllers {
+			if ctrl.Type == "scsi" {
+				return
+			}
+		}
+		domainSpec.Devices.Controllers = append(domainSpec.Devices.Controllers, domainSchema.Controller{
+			Type:  "scsi",
+			Index: "0",
+			Model: "virtio-scsi",
+		})
+	case "usb":
+		for idx, ctrl := range domainSpec.Devices.Controllers {
+			if ctrl.Type == "usb" {
+				if ctrl.Model == "none" {
+					domainSpec.Devices.Controllers[idx].Model = "qemu-xhci"
+				}
+				return
+			}
+		}
+		domainSpec.Devices.Controllers = append(domainSpec.Devices.Controllers, domainSchema.Controller{
+			Type:  "usb",
+			Index: "0",
+			Model: "qemu-xhci",
+		})
+	}
+}
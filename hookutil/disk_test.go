@@ -0,0 +1,53 @@
+package hookutil
+
+import (
+	"testing"
+
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+func TestConvertDiskBus(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Disks: []domainSchema.Disk{
+				{
+					Target: domainSchema.DiskTarget{Bus: "virtio", Device: "vda"},
+					Alias:  &domainSchema.Alias{Name: "osx-disk"},
+				},
+				{
+					Target: domainSchema.DiskTarget{Bus: "sata", Device: "sda"},
+					Alias:  &domainSchema.Alias{Name: "opencore"},
+				},
+			},
+			Controllers: []domainSchema.Controller{
+				{Type: "usb", Index: "0", Model: "none"},
+			},
+		},
+	}
+	annotations := map[string]string{
+		DiskBusAnnotation: "osx-disk:usb,opencore:floppy",
+	}
+	err := ConvertDiskBus(annotations, &domainSpec)
+	if warnings, ok := err.(Warnings); !ok || len(warnings) != 1 || warnings[0] != "Unsupported disk bus: opencore:floppy" {
+		t.Errorf("Unexpected warnings: %v", err)
+	}
+
+	disks := domainSpec.Devices.Disks
+	if disks[0].Target.Bus != "usb" || disks[0].Target.Device != "sdb" || disks[1].Target.Bus != "sata" {
+		t.Errorf("Unexpected disks, %+v", disks)
+	}
+	controllers := domainSpec.Devices.Controllers
+	if len(controllers) != 1 || controllers[0].Model != "qemu-xhci" {
+		t.Errorf("USB controller not enabled, %+v", controllers)
+	}
+}
+
+func TestNextDiskDevice(t *testing.T) {
+	disks := []domainSchema.Disk{}
+	for idx := 0; idx < 27; idx++ {
+		disks = append(disks, domainSchema.Disk{Target: domainSchema.DiskTarget{Device: nextDiskDevice("sd", disks)}})
+	}
+	if disks[0].Target.Device != "sda" || disks[25].Target.Device != "sdz" || disks[26].Target.Device != "sdaa" {
+		t.Errorf("Unexpected device names, %+v", disks)
+	}
+}
@@ -0,0 +1,319 @@
+package hookutil
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"kubevirt.io/kubevirt/pkg/hooks"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const (
+	FirmwareAnnotation      = "firmware.droidvirt.io/type" // bios or uefi
+	SecureBootAnnotation    = "firmware.droidvirt.io/secureBoot"
+	UEFILoaderAnnotation    = "firmware.droidvirt.io/loader"
+	NVRamTemplateAnnotation = "firmware.droidvirt.io/nvramTemplate" // copied once per VM
+	NVRamStorageAnnotation  = "firmware.droidvirt.io/nvramStorage"  // volume mount keeping the copy
+	MachineTypeAnnotation   = "machine.droidvirt.io/type"           // pc, q35 or a versioned name
+
+	DefaultUEFISecureLoader = "/usr/share/OVMF/OVMF_CODE.secboot.fd"
+)
+
+// FirmwareDefaults are the OVMF files of a VM whose annotations don't name them
+type FirmwareDefaults struct {
+	Loader        string
+	NVRamTemplate string
+}
+
+var DefaultFirmware = FirmwareDefaults{
+	Loader:        "/usr/share/OVMF/OVMF_CODE.fd",
+	NVRamTemplate: "/usr/share/OVMF/OVMF_VARS.fd",
+}
+
+// FirmwareRoot is where compute container files are visible to the sidecar, e.g. the OVMF volume
+// mounted into both containers, empty when mounted at the same path
+var FirmwareRoot = os.Getenv("FIRMWARE_ROOT")
+
+// NVRamDirectory keeps the per-VM NVRAM copies in the hooks directory, which the compute container mounts too
+var NVRamDirectory = filepath.Join(hooks.HookSocketsSharedDirectory, "nvram")
+
+// ConvertFirmware sets the machine type and the bios or uefi firmware the annotations ask for
+func ConvertFirmware(annotations map[string]string, defaults FirmwareDefaults, domainSpec *domainSchema.DomainSpec) error {
+	var warnings Warnings
+	if machine, found := annotations[MachineTypeAnnotation]; found {
+		if !supportedMachineType(machine) {
+			warnings.Addf("Unsupported machine type: %s", machine)
+		} else {
+			domainSpec.OS.Type.Machine = machine
+		}
+	}
+
+	firmware, found := annotations[FirmwareAnnotation]
+	if !found {
+		return warnings.Err()
+	}
+	switch firmware {
+	case "bios":
+		domainSpec.OS.BootLoader = nil
+		domainSpec.OS.NVRam = nil
+	case "uefi":
+		secureBoot, _ := strconv.ParseBool(annotations[SecureBootAnnotation])
+		if err := setUEFILoader(annotations, defaults, secureBoot, domainSpec, &warnings); err != nil {
+			return err
+		}
+	default:
+		warnings.Addf("Unsupported firmware: %s", firmware)
+	}
+	return warnings.Err()
+}
+
+func setUEFILoader(annotations map[string]string, defaults FirmwareDefaults, secureBoot bool, domainSpec *domainSchema.DomainSpec, warnings *Warnings) error {
+	loader, found := annotations[UEFILoaderAnnotation]
+	if !found {
+		loader = defaults.Loader
+		if secureBoot {
+			loader = DefaultUEFISecureLoader
+		}
+	}
+	domainSpec.OS.BootLoader = &domainSchema.Loader{
+		Path:     loader,
+		ReadOnly: "yes",
+		Secure:   "no",
+		Type:     "pflash",
+	}
+
+	if secureBoot {
+		// secure boot requires SMM, which only q35 machines emulate
+		if strings.Contains(domainSpec.OS.Type.Machine, "i440fx") || domainSpec.OS.Type.Machine == "pc" {
+			warnings.Addf("Secure boot requires a q35 machine, got %s", domainSpec.OS.Type.Machine)
+		} else {
+			domainSpec.OS.BootLoader.Secure = "yes"
+			if domainSpec.Features == nil {
+				domainSpec.Features = &domainSchema.Features{}
+			}
+			domainSpec.Features.SMM = &domainSchema.FeatureEnabled{}
+		}
+	}
+
+	template, found := annotations[NVRamTemplateAnnotation]
+	if !found {
+		template = defaults.NVRamTemplate
+	}
+	if err := SetNVRamCopy(annotations, template, domainSpec); err != nil {
+		if _, found := annotations[NVRamStorageAnnotation]; found {
+			return err
+		}
+		// libvirt creates a variable store of its own for the VM from its default template
+		warnings.Addf("Failed to copy nvram template %s: %v", template, err)
+		domainSpec.OS.NVRam = nil
+	}
+	return nil
+}
+
+// SetNVRamCopy points the domain at its own copy of the variable store template
+func SetNVRamCopy(annotations map[string]string, template string, domainSpec *domainSchema.DomainSpec) error {
+	if domainSpec.Name == "" {
+		return fmt.Errorf("domain has no name")
+	}
+	var nvram string
+	if DryRun {
+		// the path the copy would get, the store and template are on the node
+		directory, found := annotations[NVRamStorageAnnotation]
+		if !found {
+			directory = NVRamDirectory
+		}
+		nvram = nvramCopyPath(directory, domainSpec.Name)
+	} else {
+		directory, err := nvramStore(annotations)
+		if err != nil {
+			return err
+		}
+		if nvram, err = copyNVRamTemplate(template, directory, domainSpec.Name); err != nil {
+			return err
+		}
+	}
+	domainSpec.OS.NVRam = &domainSchema.NVRam{
+		Template: template,
+		NVRam:    nvram,
+	}
+	return nil
+}
+
+// nvramStore returns the directory of the VM's variable store. The hooks directory is lost with the pod,
+// a volume mounted at the same path into the sidecar and compute container keeps it across restarts.
+func nvramStore(annotations map[string]string) (string, error) {
+	directory, found := annotations[NVRamStorageAnnotation]
+	if !found {
+		return NVRamDirectory, nil
+	}
+	// don't fall back to the container filesystem when the volume isn't mounted
+	info, err := os.Stat(directory)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("nvram storage %s is not a directory", directory)
+	}
+	return directory, nil
+}
+
+// copyNVRamTemplate copies the variable store template for one VM, so VMs sharing a template
+// don't write to the same file. An existing copy is kept to preserve the variables the guest wrote.
+// qemu runs as another user in the compute container and writes the variables, so the directory
+// and the copy belong to it.
+func copyNVRamTemplate(template string, directory string, domainName string) (string, error) {
+	if err := os.MkdirAll(directory, 0770); err != nil {
+		return "", err
+	}
+	if err := os.Chown(directory, QEMUUID, QEMUGID); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(directory, 0770); err != nil {
+		return "", err
+	}
+	nvram := nvramCopyPath(directory, domainName)
+	if info, err := os.Stat(nvram); err == nil && info.Size() > 0 {
+		return nvram, nil
+	}
+
+	src, err := os.Open(filepath.Join(FirmwareRoot, template))
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(nvram+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := os.Chown(dst.Name(), QEMUUID, QEMUGID); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	if err := os.Chmod(dst.Name(), 0660); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return nvram, os.Rename(dst.Name(), nvram)
+}
+
+// nvramCopyPath is named by the domain, namespace_name, so the copy is found again after restarts and migrations
+func nvramCopyPath(directory string, domainName string) string {
+	return filepath.Join(directory, domainName+"_VARS.fd")
+}
+
+// annotations of ConvertFirmware choosing the firmware files, the domain KubeVirt defined is left to virt-launcher otherwise
+var firmwareAnnotations = []string{
+	FirmwareAnnotation,
+	SecureBootAnnotation,
+	UEFILoaderAnnotation,
+	NVRamTemplateAnnotation,
+	NVRamStorageAnnotation,
+}
+
+// FirmwareDigests are the sha256 digests the firmware files are expected to have, empty ones aren't compared
+type FirmwareDigests struct {
+	Loader        string
+	NVRamTemplate string
+}
+
+// ValidateFirmware makes sure qemu can read the firmware files the annotations chose, rather than failing
+// to start the domain deep in virt-launcher. sidecarAnnotations are the sidecar's own firmware annotations.
+func ValidateFirmware(annotations map[string]string, sidecarAnnotations []string, digests FirmwareDigests, domainSpec *domainSchema.DomainSpec) error {
+	if DryRun {
+		// the files are checked on the node
+		return nil
+	}
+	annotated := false
+	for _, annotation := range append(firmwareAnnotations, sidecarAnnotations...) {
+		if _, found := annotations[annotation]; found {
+			annotated = true
+			break
+		}
+	}
+	if !annotated {
+		return nil
+	}
+
+	if loader := domainSpec.OS.BootLoader; loader != nil && loader.Path != "" {
+		if err := verifyFirmwareFile(loader.Path, digests.Loader); err != nil {
+			return fmt.Errorf("invalid loader: %v", err)
+		}
+	}
+
+	nvram := domainSpec.OS.NVRam
+	switch {
+	case nvram == nil:
+	case nvram.Template != "":
+		// only the read-only template, the guest writes the copy while running
+		if err := verifyFirmwareFile(nvram.Template, digests.NVRamTemplate); err != nil {
+			return fmt.Errorf("invalid nvram template: %v", err)
+		}
+	case nvram.NVRam != "":
+		if err := verifyNVRamStore(nvram.NVRam); err != nil {
+			return fmt.Errorf("invalid nvram: %v", err)
+		}
+	}
+	return nil
+}
+
+// verifyFirmwareFile reads the whole file, and compares its sha256 with the digest if given
+func verifyFirmwareFile(path string, digest string) error {
+	file, err := os.Open(filepath.Join(FirmwareRoot, path))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if digest == "" {
+		return nil
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != strings.ToLower(strings.TrimPrefix(digest, "sha256:")) {
+		return fmt.Errorf("%s has sha256 %s, expected %s", path, sum, digest)
+	}
+	return nil
+}
+
+// verifyNVRamStore checks a variable store given without template, libvirt creates a missing one
+// from its default template but not the directory it's in
+func verifyNVRamStore(path string) error {
+	info, err := os.Stat(filepath.Join(FirmwareRoot, path))
+	if os.IsNotExist(err) {
+		dir := filepath.Dir(path)
+		if info, err := os.Stat(filepath.Join(FirmwareRoot, dir)); err != nil || !info.IsDir() {
+			return fmt.Errorf("directory %s of %s not found", dir, path)
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	return nil
+}
+
+// supportedMachineType accepts the pc and q35 aliases and their versioned names, e.g. pc-q35-4.2
+func supportedMachineType(machine string) bool {
+	return machine == "pc" || machine == "q35" ||
+		strings.HasPrefix(machine, "pc-q35-") || strings.HasPrefix(machine, "pc-i440fx-")
+}
@@ -0,0 +1,100 @@
+package hookutil
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+func TestCopyNVRamTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvram")
+	if err != nil {
+		t.Fatalf("Failed to create nvram dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(uid, gid int) { QEMUUID, QEMUGID = uid, gid }(QEMUUID, QEMUGID)
+	QEMUUID, QEMUGID = os.Getuid(), os.Getgid()
+
+	template := dir + "/OVMF_VARS.fd"
+	if err := ioutil.WriteFile(template, []byte("vars"), 0644); err != nil {
+		t.Fatalf("Failed to create nvram template")
+	}
+	nvram, err := copyNVRamTemplate(template, dir+"/nvram", "default_android")
+	if err != nil {
+		t.Fatalf("Failed to copy nvram template: %v", err)
+	}
+	if nvram != dir+"/nvram/default_android_VARS.fd" {
+		t.Errorf("Unexpected nvram path %s", nvram)
+	}
+
+	for path, mode := range map[string]os.FileMode{dir + "/nvram": 0770, nvram: 0660} {
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != mode {
+			t.Fatalf("Unexpected mode of %s, %v", path, err)
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && (int(stat.Uid) != QEMUUID || int(stat.Gid) != QEMUGID) {
+			t.Errorf("%s not owned by qemu, %d:%d", path, stat.Uid, stat.Gid)
+		}
+	}
+
+	// the guest's variables survive a restart
+	ioutil.WriteFile(nvram, []byte("written by guest"), 0660)
+	if _, err := copyNVRamTemplate(template, dir+"/nvram", "default_android"); err != nil {
+		t.Fatalf("Failed to keep nvram copy: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(nvram); string(data) != "written by guest" {
+		t.Errorf("NVRAM copy overwritten, %s", data)
+	}
+}
+
+func TestValidateFirmware(t *testing.T) {
+	root, err := ioutil.TempDir("", "firmware")
+	if err != nil {
+		t.Fatalf("Failed to create firmware root")
+	}
+	defer os.RemoveAll(root)
+	defer func(path string) { FirmwareRoot = path }(FirmwareRoot)
+	FirmwareRoot = root
+	os.MkdirAll(root+"/usr/share/OVMF", 0755)
+	ioutil.WriteFile(root+"/usr/share/OVMF/OVMF_CODE.fd", []byte("code"), 0644)
+
+	annotations := map[string]string{FirmwareAnnotation: "uefi"}
+	domainSpec := domainSchema.DomainSpec{}
+	domainSpec.OS.BootLoader = &domainSchema.Loader{Path: "/usr/share/OVMF/OVMF_CODE.secboot.fd"}
+	if err := ValidateFirmware(annotations, nil, FirmwareDigests{}, &domainSpec); err == nil || !strings.Contains(err.Error(), "invalid loader") {
+		t.Errorf("Missing loader not detected, %v", err)
+	}
+
+	// sha256 of "code"
+	digests := FirmwareDigests{Loader: "5694d08a2e53ffcae0c3103e5ad6f6076abd960eb1f8a56577040bc1028f702b"}
+	domainSpec.OS.BootLoader.Path = "/usr/share/OVMF/OVMF_CODE.fd"
+	if err := ValidateFirmware(annotations, nil, digests, &domainSpec); err != nil {
+		t.Errorf("Unexpected error, %v", err)
+	}
+
+	// a variable store without template is created by libvirt, but not its directory
+	domainSpec.OS.NVRam = &domainSchema.NVRam{NVRam: "/usr/share/OVMF/default_android_VARS.fd"}
+	if err := ValidateFirmware(annotations, nil, digests, &domainSpec); err != nil {
+		t.Errorf("Unexpected error, %v", err)
+	}
+	domainSpec.OS.NVRam.NVRam = "/var/lib/nvram/default_android_VARS.fd"
+	if err := ValidateFirmware(annotations, nil, digests, &domainSpec); err == nil || !strings.Contains(err.Error(), "invalid nvram") {
+		t.Errorf("Missing nvram directory not detected, %v", err)
+	}
+	domainSpec.OS.NVRam.NVRam = "/usr/share/OVMF"
+	if err := ValidateFirmware(annotations, nil, digests, &domainSpec); err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("Invalid nvram not detected, %v", err)
+	}
+
+	// firmware KubeVirt chose isn't checked without firmware annotations, the sidecar's own count too
+	if err := ValidateFirmware(map[string]string{}, nil, digests, &domainSpec); err != nil {
+		t.Errorf("Unexpected error, %v", err)
+	}
+	if err := ValidateFirmware(map[string]string{"loader.osx-kvm.io/path": ""}, []string{"loader.osx-kvm.io/path"}, digests, &domainSpec); err == nil {
+		t.Errorf("Sidecar firmware annotation not validated")
+	}
+}
@@ -0,0 +1,107 @@
+// Package golden compares the domains a sidecar renders for annotation sets with the expected ones,
+// each case directory has annotations.yaml and expected.xml, and optionally domain.xml
+// replacing the shared one of the golden directory
+package golden
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"kubevirt.io/client-go/api/v1"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	"kubevirt.io/kubevirt/pkg/hooks"
+	hooksV1alpha1 "kubevirt.io/kubevirt/pkg/hooks/v1alpha1"
+)
+
+// go test -run TestGolden -update rewrites expected.xml of every case
+var update = flag.Bool("update", false, "update expected.xml of golden cases")
+
+// Setup points the sidecar state at the case directory and a temporary hooks directory,
+// it returns the server to render with and a func restoring the state
+type Setup func(dir string, hooksDir string) (hookutil.DomainDefiner, func())
+
+// Run renders every case under directory as a subtest
+func Run(t *testing.T, directory string, setup Setup) {
+	cases, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("Failed to list golden cases: %v", err)
+	}
+	for _, c := range cases {
+		if c.IsDir() {
+			dir := filepath.Join(directory, c.Name())
+			t.Run(c.Name(), func(t *testing.T) { runCase(t, directory, dir, setup) })
+		}
+	}
+}
+
+func runCase(t *testing.T, directory string, dir string, setup Setup) {
+	hooksDir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatalf("Failed to create hooks dir")
+	}
+	defer os.RemoveAll(hooksDir)
+	defer func(path string) { hookutil.NVRamDirectory = path }(hookutil.NVRamDirectory)
+	defer func(uid, gid int) { hookutil.QEMUUID, hookutil.QEMUGID = uid, gid }(hookutil.QEMUUID, hookutil.QEMUGID)
+	hookutil.NVRamDirectory = filepath.Join(hooksDir, "nvram")
+	hookutil.QEMUUID, hookutil.QEMUGID = os.Getuid(), os.Getgid()
+	server, restore := setup(dir, hooksDir)
+	defer restore()
+
+	domainXML, err := ioutil.ReadFile(filepath.Join(dir, "domain.xml"))
+	if os.IsNotExist(err) {
+		domainXML, err = ioutil.ReadFile(filepath.Join(directory, "domain.xml"))
+	}
+	if err != nil {
+		t.Fatalf("Failed to read domain: %v", err)
+	}
+
+	annotationsYAML, err := ioutil.ReadFile(filepath.Join(dir, "annotations.yaml"))
+	if err != nil {
+		t.Fatalf("Failed to read annotations: %v", err)
+	}
+	annotations := map[string]string{}
+	if err := yaml.Unmarshal(annotationsYAML, &annotations); err != nil {
+		t.Fatalf("Invalid annotations: %v", err)
+	}
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(annotations)
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Fatalf("Failed to marshal JSON")
+	}
+
+	params := hooksV1alpha1.OnDefineDomainParams{DomainXML: domainXML, Vmi: vmiJSON}
+	result, err := server.OnDefineDomain(context.TODO(), &params)
+	if err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+
+	// compare the marshalled XML, qemu:commandline doesn't survive xml.Unmarshal
+	actual, err := hookutil.IndentXML(result.GetDomainXML())
+	if err != nil {
+		t.Fatalf("Failed to indent domain: %v", err)
+	}
+	actual = append(bytes.Replace(actual, []byte(hooksDir), []byte(hooks.HookSocketsSharedDirectory), -1), '\n')
+
+	expectedPath := filepath.Join(dir, "expected.xml")
+	if *update {
+		if err := ioutil.WriteFile(expectedPath, actual, 0644); err != nil {
+			t.Fatalf("Failed to update %s: %v", expectedPath, err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatalf("Failed to read expected domain: %v", err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("Domain differs from %s, rerun with -update to accept:\n%s", expectedPath, actual)
+	}
+}
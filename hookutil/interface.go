@@ -0,0 +1,187 @@
+package hookutil
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const (
+	NICMACAnnotation         = "nic.droidvirt.io/mac" // alias:value pairs split by comma
+	NICMTUAnnotation         = "nic.droidvirt.io/mtu"
+	NICQueuesAnnotation      = "nic.droidvirt.io/queues"
+	NICLinkStateAnnotation   = "nic.droidvirt.io/linkState"
+	NICRxQueueSizeAnnotation = "nic.droidvirt.io/rxQueueSize" // virtio only
+	NICTxQueueSizeAnnotation = "nic.droidvirt.io/txQueueSize"
+)
+
+// virtio NIC models, the transitional ones take the same driver options
+var virtioNICModels = map[string]bool{
+	"virtio":                  true,
+	"virtio-transitional":     true,
+	"virtio-non-transitional": true,
+}
+
+// InterfaceQueueSizes keeps the rx and tx queue sizes by interface alias until the domain is marshalled,
+// the driver element of the domain schema has no attributes for them
+type InterfaceQueueSizes map[string]*interfaceQueueSize
+
+type interfaceQueueSize struct {
+	rx uint64
+	tx uint64
+}
+
+// Expand adds the queue sizes to the driver elements of the interfaces in the marshalled domain
+func (sizes InterfaceQueueSizes) Expand(domainXML []byte) []byte {
+	for alias, size := range sizes {
+		aliasElement := &bytes.Buffer{}
+		aliasElement.WriteString(`<alias name="`)
+		xml.EscapeText(aliasElement, []byte(alias))
+		aliasElement.WriteString(`"></alias>`)
+
+		// the driver element follows the alias in an interface, a later converter may have dropped both
+		idx := bytes.Index(domainXML, aliasElement.Bytes())
+		if idx < 0 || bytes.LastIndex(domainXML[:idx], []byte("<interface ")) < bytes.LastIndex(domainXML[:idx], []byte("</interface>")) {
+			log.Log.Errorf("Interface %s not found in domain, queue sizes are not set", alias)
+			continue
+		}
+		end := bytes.Index(domainXML[idx:], []byte("</interface>"))
+		driver := bytes.Index(domainXML[idx:], []byte("<driver "))
+		if end < 0 || driver < 0 || driver > end {
+			log.Log.Errorf("Interface %s has no driver, queue sizes are not set", alias)
+			continue
+		}
+		driver += idx + len("<driver")
+
+		attrs := ""
+		if size.rx > 0 {
+			attrs += fmt.Sprintf(` rx_queue_size="%d"`, size.rx)
+		}
+		if size.tx > 0 {
+			attrs += fmt.Sprintf(` tx_queue_size="%d"`, size.tx)
+		}
+		result := make([]byte, 0, len(domainXML)+len(attrs))
+		result = append(result, domainXML[:driver]...)
+		result = append(result, attrs...)
+		domainXML = append(result, domainXML[driver:]...)
+	}
+	return domainXML
+}
+
+// ConvertInterfaceOptions sets MAC, MTU, queues, queue sizes and link state of the interfaces by alias
+func ConvertInterfaceOptions(annotations map[string]string, queueSizes InterfaceQueueSizes, domainSpec *domainSchema.DomainSpec) error {
+	// alias:value pairs split by comma, e.g. "default:52:54:00:12:34:56"
+	var warnings Warnings
+	macs := ParseAliasValues(annotations[NICMACAnnotation], &warnings)
+	mtus := ParseAliasValues(annotations[NICMTUAnnotation], &warnings)
+	queues := ParseAliasValues(annotations[NICQueuesAnnotation], &warnings)
+	linkStates := ParseAliasValues(annotations[NICLinkStateAnnotation], &warnings)
+	rxQueueSizes := ParseAliasValues(annotations[NICRxQueueSizeAnnotation], &warnings)
+	txQueueSizes := ParseAliasValues(annotations[NICTxQueueSizeAnnotation], &warnings)
+
+	for idx, nicDevice := range domainSpec.Devices.Interfaces {
+		if nicDevice.Alias == nil {
+			continue
+		}
+		name := nicDevice.Alias.Name
+		nic := &domainSpec.Devices.Interfaces[idx]
+
+		if macStr, found := macs[name]; found {
+			mac, err := net.ParseMAC(macStr)
+			if err != nil || len(mac) != 6 || mac[0]&1 != 0 {
+				warnings.Addf("Invalid MAC address of %s: %s", name, macStr)
+			} else {
+				nic.MAC = &domainSchema.MAC{MAC: mac.String()}
+			}
+		}
+
+		if mtuStr, found := mtus[name]; found {
+			mtu, err := strconv.ParseUint(mtuStr, 10, 16)
+			if err != nil || mtu < 68 {
+				warnings.Addf("Invalid MTU of %s: %s", name, mtuStr)
+			} else {
+				nic.MTU = &domainSchema.MTU{Size: mtuStr}
+			}
+		}
+
+		if queueStr, found := queues[name]; found {
+			count, err := strconv.ParseUint(queueStr, 10, 32)
+			if err != nil || count < 1 || count > 256 {
+				warnings.Addf("Invalid queue count of %s: %s", name, queueStr)
+			} else if nic.Model == nil || !virtioNICModels[nic.Model.Type] {
+				warnings.Addf("Multiqueue needs a virtio NIC: %s", name)
+			} else {
+				queueCount := uint(count)
+				if nic.Driver == nil {
+					nic.Driver = &domainSchema.InterfaceDriver{Name: "vhost"}
+				}
+				nic.Driver.Queues = &queueCount
+			}
+		}
+
+		rxStr, rxFound := rxQueueSizes[name]
+		txStr, txFound := txQueueSizes[name]
+		if rxFound || txFound {
+			if nic.Model == nil || !virtioNICModels[nic.Model.Type] {
+				warnings.Addf("Queue sizes need a virtio NIC: %s", name)
+			} else {
+				size := &interfaceQueueSize{}
+				if rxFound {
+					size.rx = parseQueueSize("rx", name, rxStr, &warnings)
+				}
+				if txFound {
+					size.tx = parseQueueSize("tx", name, txStr, &warnings)
+				}
+				if size.rx > 0 || size.tx > 0 {
+					if nic.Driver == nil {
+						nic.Driver = &domainSchema.InterfaceDriver{Name: "vhost"}
+					}
+					queueSizes[name] = size
+				}
+			}
+		}
+
+		if state, found := linkStates[name]; found {
+			if state != "up" && state != "down" {
+				warnings.Addf("Invalid link state of %s: %s", name, state)
+			} else {
+				nic.LinkState = &domainSchema.LinkState{State: state}
+			}
+		}
+	}
+
+	return warnings.Err()
+}
+
+// parseQueueSize returns 0 for sizes virtio-net doesn't take, a power of 2 between 256 and 1024
+func parseQueueSize(queue string, name string, sizeStr string, warnings *Warnings) uint64 {
+	size, err := strconv.ParseUint(sizeStr, 10, 16)
+	if err != nil || size < 256 || size > 1024 || size&(size-1) != 0 {
+		warnings.Addf("Invalid %s queue size of %s: %s", queue, name, sizeStr)
+		return 0
+	}
+	return size
+}
+
+// ParseAliasValues splits key:value pairs by comma, rejecting the pairs without a colon
+func ParseAliasValues(pairs string, warnings *Warnings) map[string]string {
+	values := make(map[string]string)
+	if pairs == "" {
+		return values
+	}
+	for _, pair := range strings.Split(pairs, ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			warnings.Addf("Invalid alias value pair: %s", pair)
+			continue
+		}
+		values[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return values
+}
@@ -0,0 +1,91 @@
+package hookutil
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+func TestConvertInterfaceOptions(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Interfaces: []domainSchema.Interface{
+				{
+					Type:  "bridge",
+					Model: &domainSchema.Model{Type: "virtio"},
+					Alias: &domainSchema.Alias{Name: "default"},
+				},
+				{
+					Type:  "bridge",
+					Model: &domainSchema.Model{Type: "e1000"},
+					Alias: &domainSchema.Alias{Name: "net1"},
+				},
+				{
+					Type:  "bridge",
+					Model: &domainSchema.Model{Type: "virtio-transitional"},
+					Alias: &domainSchema.Alias{Name: "net2"},
+				},
+			},
+		},
+	}
+
+	annotations := map[string]string{
+		NICMACAnnotation:         "default:52:54:00:AB:CD:EF,net1:01:00:5e:00:00:01",
+		NICMTUAnnotation:         "default:9000",
+		NICQueuesAnnotation:      "default:4,net1:4,net2:2",
+		NICLinkStateAnnotation:   "net1:down",
+		NICRxQueueSizeAnnotation: "default:1024,net1:512,net2:256",
+		NICTxQueueSizeAnnotation: "default:1000",
+	}
+	queueSizes := make(InterfaceQueueSizes)
+	err := ConvertInterfaceOptions(annotations, queueSizes, &domainSpec)
+	expected := Warnings{
+		"Invalid tx queue size of default: 1000",
+		"Invalid MAC address of net1: 01:00:5e:00:00:01",
+		"Multiqueue needs a virtio NIC: net1",
+		"Queue sizes need a virtio NIC: net1",
+	}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Unexpected warnings: %v", err)
+	}
+
+	nic := domainSpec.Devices.Interfaces[0]
+	if nic.MAC == nil || nic.MAC.MAC != "52:54:00:ab:cd:ef" || nic.MTU == nil || nic.MTU.Size != "9000" ||
+		nic.Driver == nil || nic.Driver.Queues == nil || *nic.Driver.Queues != 4 {
+		t.Errorf("Interface options not set, %+v", nic)
+	}
+
+	nic = domainSpec.Devices.Interfaces[1]
+	if nic.MAC != nil || nic.Driver != nil || nic.LinkState == nil || nic.LinkState.State != "down" {
+		t.Errorf("Unexpected interface options, %+v", nic)
+	}
+
+	nic = domainSpec.Devices.Interfaces[2]
+	if nic.Driver == nil || nic.Driver.Queues == nil || *nic.Driver.Queues != 2 {
+		t.Errorf("Multiqueue not set on a transitional virtio NIC, %+v", nic)
+	}
+
+	// queue sizes are set per interface, not by qemu args for every virtio NIC
+	if domainSpec.QEMUCmd != nil {
+		t.Errorf("Unexpected qemu args, %+v", domainSpec.QEMUCmd)
+	}
+	domainXML, err := xml.Marshal(domainSpec)
+	if err != nil {
+		t.Fatalf("Failed to marshal domain: %v", err)
+	}
+	domainXML = queueSizes.Expand(domainXML)
+	for _, driver := range []string{
+		`<alias name="default"></alias><driver rx_queue_size="1024" name="vhost" queues="4"></driver>`,
+		`<alias name="net2"></alias><driver rx_queue_size="256" name="vhost" queues="2"></driver>`,
+	} {
+		if !strings.Contains(string(domainXML), driver) {
+			t.Errorf("Driver %s not found in %s", driver, domainXML)
+		}
+	}
+	if strings.Count(string(domainXML), "queue_size") != 2 {
+		t.Errorf("Unexpected queue sizes in %s", domainXML)
+	}
+}
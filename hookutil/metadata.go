@@ -0,0 +1,99 @@
+package hookutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+// ConversionRecord tells what a sidecar did to the domain, kept in the domain metadata
+// since the metadata of the domain schema only holds the kubevirt element
+type ConversionRecord struct {
+	XMLName    xml.Name `xml:"http://droidvirt.io/domain/1.0 droidvirt"`
+	Sidecar    string   `xml:"sidecar"`
+	Version    string   `xml:"version"`
+	Converters []string `xml:"converters>converter"`
+	InputsHash string   `xml:"inputs"`
+	Warnings   []string `xml:"warning"`
+}
+
+func NewConversionRecord(sidecar string, version string, inputsHash string) *ConversionRecord {
+	return &ConversionRecord{
+		Sidecar:    sidecar,
+		Version:    version,
+		InputsHash: inputsHash,
+	}
+}
+
+// Apply runs a converter, which is recorded only when it changed the domain. The annotations it
+// rejected become warnings, any other error refuses the domain and is returned.
+func (r *ConversionRecord) Apply(name string, domainSpec *domainSchema.DomainSpec, convert func() error) error {
+	before, _ := xml.Marshal(domainSpec)
+	err := convert()
+	if warnings, ok := err.(Warnings); ok {
+		ValidationErrors.WithLabelValues(CheckAnnotation).Add(float64(len(warnings)))
+		r.Warnings = append(r.Warnings, warnings...)
+		err = nil
+	}
+	if err != nil {
+		ValidationErrors.WithLabelValues(CheckRefused).Inc()
+		log.Log.Reason(err).Errorf("Converter %s refused the domain", name)
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	if after, _ := xml.Marshal(domainSpec); !bytes.Equal(before, after) {
+		r.Converters = append(r.Converters, name)
+	}
+	return nil
+}
+
+// AddTo puts the record into the metadata element of the marshalled domain
+func (r *ConversionRecord) AddTo(domainXML []byte) ([]byte, error) {
+	record, err := xml.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := bytes.LastIndex(domainXML, []byte("</metadata>"))
+	if idx < 0 {
+		idx = bytes.LastIndex(domainXML, []byte("</domain>"))
+		if idx < 0 {
+			return nil, fmt.Errorf("domain element not found")
+		}
+		record = append(append([]byte("<metadata>"), record...), []byte("</metadata>")...)
+	}
+
+	result := make([]byte, 0, len(domainXML)+len(record))
+	result = append(result, domainXML[:idx]...)
+	result = append(result, record...)
+	return append(result, domainXML[idx:]...), nil
+}
+
+// InputsHash digests the annotations of the given domains and the domain given to the sidecar,
+// so domains converted from the same inputs can be told apart from the others
+func InputsHash(annotations map[string]string, annotationDomains []string, domainXML []byte) string {
+	keys := []string{}
+	for key := range annotations {
+		for _, domain := range annotationDomains {
+			if strings.Contains(key, domain) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, annotations[key])
+	}
+	hash.Write(domainXML)
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,69 @@
+package hookutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+func TestConversionRecordApply(t *testing.T) {
+	rejected := testutil.ToFloat64(ValidationErrors.WithLabelValues(CheckAnnotation))
+	refused := testutil.ToFloat64(ValidationErrors.WithLabelValues(CheckRefused))
+	record := NewConversionRecord("test", "dev", InputsHash(nil, nil, nil))
+	domainSpec := &domainSchema.DomainSpec{}
+
+	noop := func() error { return nil }
+	if err := record.Apply("noop", domainSpec, noop); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	rename := func() error {
+		domainSpec.Name = "default_test"
+		var warnings Warnings
+		warnings.Addf("Invalid option: %s", "foo")
+		return warnings.Err()
+	}
+	if err := record.Apply("rename", domainSpec, rename); err != nil {
+		t.Errorf("Warnings refused the domain: %v", err)
+	}
+	refuse := func() error {
+		domainSpec.Name = "refused"
+		return fmt.Errorf("device not allowed")
+	}
+	if err := record.Apply("refuse", domainSpec, refuse); err == nil || err.Error() != "refuse: device not allowed" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if len(record.Converters) != 1 || record.Converters[0] != "rename" ||
+		len(record.Warnings) != 1 || record.Warnings[0] != "Invalid option: foo" {
+		t.Errorf("Unexpected conversion record, %+v", record)
+	}
+	if testutil.ToFloat64(ValidationErrors.WithLabelValues(CheckAnnotation)) != rejected+1 ||
+		testutil.ToFloat64(ValidationErrors.WithLabelValues(CheckRefused)) != refused+1 {
+		t.Errorf("Validation errors not counted")
+	}
+}
+
+func TestConversionRecordAddTo(t *testing.T) {
+	record := NewConversionRecord("test", "dev", "sha256:0")
+	record.Warnings = []string{"unknown converter foo"}
+
+	domainXML, err := record.AddTo([]byte(`<domain><metadata><kubevirt></kubevirt></metadata></domain>`))
+	if err != nil {
+		t.Fatalf("Failed to add record: %v", err)
+	}
+	if !strings.HasPrefix(string(domainXML), `<domain><metadata><kubevirt></kubevirt><droidvirt xmlns="http://droidvirt.io/domain/1.0">`) ||
+		!strings.Contains(string(domainXML), `<warning>unknown converter foo</warning>`) {
+		t.Errorf("Unexpected domain, %s", domainXML)
+	}
+
+	domainXML, err = record.AddTo([]byte(`<domain></domain>`))
+	if err != nil || !strings.HasPrefix(string(domainXML), `<domain><metadata><droidvirt `) {
+		t.Errorf("Metadata not added, %s, %v", domainXML, err)
+	}
+	if _, err := record.AddTo([]byte(`<vm></vm>`)); err == nil {
+		t.Errorf("Record added without domain element")
+	}
+}
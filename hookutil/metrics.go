@@ -0,0 +1,106 @@
+package hookutil
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"kubevirt.io/client-go/log"
+)
+
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+
+	// label of converter names the sidecar doesn't know, they come from the VMI and would grow the label set
+	UnknownConverter = "unknown"
+)
+
+// checks labelling ValidationErrors, the same set in every sidecar
+const (
+	CheckProfile     = "profile"     // the VM profile the annotations name can't be applied
+	CheckConverter   = "converter"   // the VMI has no converter annotation
+	CheckAnnotation  = "annotation"  // a converter rejected an annotation, or the converter name is unknown
+	CheckRefused     = "refused"     // a converter refused the domain
+	CheckControllers = "controllers" // the converted domain has conflicting controllers
+	CheckFirmware    = "firmware"    // the firmware files of the converted domain aren't usable
+)
+
+var (
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "droidvirt",
+		Subsystem: "hook",
+		Name:      "grpc_requests_total",
+		Help:      "Hook calls handled by the sidecar, e.g. OnDefineDomain, by result.",
+	}, []string{"method", "result"})
+
+	grpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "droidvirt",
+		Subsystem: "hook",
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Time the sidecar took to handle hook calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	ConverterRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "droidvirt",
+		Subsystem: "hook",
+		Name:      "converter_runs_total",
+		Help:      "Converter runs by result, a failure is a converter rejecting annotations or refusing the domain.",
+	}, []string{"converter", "result"})
+
+	ValidationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "droidvirt",
+		Subsystem: "hook",
+		Name:      "validation_errors_total",
+		Help:      "Problems found in the VMI annotations or the converted domain, by check.",
+	}, []string{"check"})
+)
+
+// MetricsInterceptor counts and times the hook calls. grpc-go doesn't recover panics,
+// a panicking call takes the sidecar down along with its metrics.
+func MetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+	start := time.Now()
+	result := ResultFailure
+	defer func() {
+		grpcRequests.WithLabelValues(method, result).Inc()
+		grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	}()
+
+	resp, err := handler(ctx, req)
+	if err == nil {
+		result = ResultSuccess
+	}
+	return resp, err
+}
+
+// ObserveConverter counts the result of a converter run, rejected annotations count as a failure
+// like a refused domain
+func ObserveConverter(name string, convert func() error) error {
+	result := ResultFailure
+	defer func() { ConverterRuns.WithLabelValues(name, result).Inc() }()
+
+	err := convert()
+	if err == nil {
+		result = ResultSuccess
+	}
+	return err
+}
+
+// ServeMetrics registers the metrics labelled with the sidecar and serves them on address
+func ServeMetrics(sidecar string, address string) {
+	prometheus.WrapRegistererWith(prometheus.Labels{"sidecar": sidecar}, prometheus.DefaultRegisterer).
+		MustRegister(grpcRequests, grpcDuration, ConverterRuns, ValidationErrors)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Log.Infof("Serving metrics on %s/metrics", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Log.Reason(err).Error("Failed to serve metrics")
+	}
+}
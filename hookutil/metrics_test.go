@@ -0,0 +1,37 @@
+package hookutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/kubevirt.hooks.v1alpha1.Callbacks/OnDefineDomain"}
+	failures := testutil.ToFloat64(grpcRequests.WithLabelValues("OnDefineDomain", ResultFailure))
+	MetricsInterceptor(context.TODO(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("host device refused")
+	})
+	if testutil.ToFloat64(grpcRequests.WithLabelValues("OnDefineDomain", ResultFailure)) != failures+1 {
+		t.Errorf("Failed call not counted")
+	}
+}
+
+func TestObserveConverter(t *testing.T) {
+	successes := testutil.ToFloat64(ConverterRuns.WithLabelValues("test", ResultSuccess))
+	failures := testutil.ToFloat64(ConverterRuns.WithLabelValues("test", ResultFailure))
+
+	ObserveConverter("test", func() error { return nil })
+	if err := ObserveConverter("test", func() error { return Warnf("Invalid value") }); err == nil {
+		t.Errorf("Warnings not returned")
+	}
+	ObserveConverter("test", func() error { return fmt.Errorf("refused") })
+
+	if testutil.ToFloat64(ConverterRuns.WithLabelValues("test", ResultSuccess)) != successes+1 ||
+		testutil.ToFloat64(ConverterRuns.WithLabelValues("test", ResultFailure)) != failures+2 {
+		t.Errorf("Converter runs not counted")
+	}
+}
@@ -0,0 +1,160 @@
+package hookutil
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	k8sv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	v1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/client-go/kubecli"
+	"kubevirt.io/client-go/log"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const (
+	GraphicsStatusAnnotation = "status.droidvirt.io/graphics" // effective graphics ports, written by the sidecar
+
+	// bounds every request to the API server, a slow one must not pile up goroutines per domain definition
+	publishTimeout = 10 * time.Second
+)
+
+// VMIClient is what publishing results needs from the KubeVirt client besides events
+type VMIClient interface {
+	PatchVMI(namespace string, name string, patch []byte) error
+}
+
+type kubevirtVMIClient struct {
+	client kubecli.KubevirtClient
+}
+
+func (c kubevirtVMIClient) PatchVMI(namespace string, name string, patch []byte) error {
+	_, err := c.client.VirtualMachineInstance(namespace).Patch(name, types.MergePatchType, patch)
+	return err
+}
+
+// ResultPublisher tells the VMI owner what the sidecar did by events and a status annotation,
+// the service account of the pod needs to create events and patch VMIs
+type ResultPublisher struct {
+	client   VMIClient
+	recorder record.EventRecorder
+}
+
+func NewResultPublisher(client VMIClient, recorder record.EventRecorder) *ResultPublisher {
+	return &ResultPublisher{client: client, recorder: recorder}
+}
+
+// NewInClusterResultPublisher publishes by the pod's service account, events of the component
+// are aggregated and rate limited by the client-go event broadcaster
+func NewInClusterResultPublisher(component string) (*ResultPublisher, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout = publishTimeout
+	client, err := kubecli.GetKubevirtClientFromRESTConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, k8sv1.EventSource{Component: component})
+	return NewResultPublisher(kubevirtVMIClient{client: client}, recorder), nil
+}
+
+// Publish doesn't hold the domain definition back, a nil publisher publishes nothing
+func (p *ResultPublisher) Publish(vmi *v1.VirtualMachineInstance, record *ConversionRecord, domainSpec *domainSchema.DomainSpec, convertErr error) {
+	if p == nil {
+		return
+	}
+	// the hook gets the VMI without its kind, which events refer to it by
+	if vmi.GetObjectKind().GroupVersionKind().Kind == "" {
+		vmi.GetObjectKind().SetGroupVersionKind(v1.VirtualMachineInstanceGroupVersionKind)
+	}
+	go p.publishResults(vmi, record, domainSpec, convertErr)
+}
+
+func (p *ResultPublisher) publishResults(vmi *v1.VirtualMachineInstance, record *ConversionRecord, domainSpec *domainSchema.DomainSpec, convertErr error) {
+	if convertErr != nil {
+		p.recorder.Event(vmi, k8sv1.EventTypeWarning, "ConversionFailed", convertErr.Error())
+		return
+	}
+
+	if record != nil {
+		p.recorder.Event(vmi, k8sv1.EventTypeNormal, "Converted", "Applied converters: "+strings.Join(record.Converters, ", "))
+		for _, warning := range record.Warnings {
+			p.recorder.Event(vmi, k8sv1.EventTypeWarning, "ConversionWarning", warning)
+		}
+	}
+
+	status := graphicsStatus(domainSpec)
+	if status == nil {
+		return
+	}
+	statusJSON, err := json.Marshal(status)
+	if err != nil {
+		log.Log.Reason(err).Error("Failed to marshal graphics status")
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				GraphicsStatusAnnotation: string(statusJSON),
+			},
+		},
+	})
+	if err != nil {
+		log.Log.Reason(err).Error("Failed to marshal VMI patch")
+		return
+	}
+	if err := p.client.PatchVMI(vmi.GetNamespace(), vmi.GetName(), patch); err != nil {
+		log.Log.Reason(err).Errorf("Failed to annotate VMI %s/%s", vmi.GetNamespace(), vmi.GetName())
+	}
+}
+
+type graphicsPorts struct {
+	VNC       int64 `json:"vnc,omitempty"`
+	WebSocket int64 `json:"websocket,omitempty"`
+}
+
+// graphicsStatus returns the VNC ports of the domain, either from graphics devices or the qemu -vnc option
+func graphicsStatus(domainSpec *domainSchema.DomainSpec) *graphicsPorts {
+	ports := &graphicsPorts{}
+	for _, graphics := range domainSpec.Devices.Graphics {
+		if graphics.Type == "vnc" && graphics.Port > 0 {
+			ports.VNC = int64(graphics.Port)
+		}
+	}
+
+	if domainSpec.QEMUCmd != nil {
+		args := domainSpec.QEMUCmd.QEMUArg
+		for idx := 0; idx+1 < len(args); idx++ {
+			if args[idx].Value != "-vnc" {
+				continue
+			}
+			// e.g. 0.0.0.0:1,websocket=5911
+			options := strings.Split(args[idx+1].Value, ",")
+			display := options[0][strings.LastIndex(options[0], ":")+1:]
+			if n, err := strconv.ParseInt(display, 10, 32); err == nil {
+				ports.VNC = 5900 + n
+			}
+			for _, option := range options[1:] {
+				if strings.HasPrefix(option, "websocket=") {
+					ports.WebSocket, _ = strconv.ParseInt(strings.TrimPrefix(option, "websocket="), 10, 32)
+				}
+			}
+		}
+	}
+
+	if ports.VNC == 0 && ports.WebSocket == 0 {
+		return nil
+	}
+	return ports
+}
@@ -0,0 +1,67 @@
+package hookutil
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/record"
+	v1 "kubevirt.io/client-go/api/v1"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+type fakeVMIClient struct {
+	patches []string
+}
+
+func (c *fakeVMIClient) PatchVMI(namespace string, name string, patch []byte) error {
+	c.patches = append(c.patches, namespace+"/"+name+" "+string(patch))
+	return nil
+}
+
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	events := make([]string, 0)
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	return events
+}
+
+func TestPublishResults(t *testing.T) {
+	client := &fakeVMIClient{}
+	recorder := record.NewFakeRecorder(10)
+	publisher := NewResultPublisher(client, recorder)
+
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.Name = "android"
+	vmi.Namespace = "default"
+	domainSpec := domainSchema.DomainSpec{}
+	domainSpec.Devices.Graphics = []domainSchema.Graphics{{Type: "vnc", Port: 5901}}
+	domainSpec.QEMUCmd = &domainSchema.Commandline{QEMUArg: []domainSchema.Arg{{Value: "-vnc"}, {Value: "0.0.0.0:1,websocket=5911"}}}
+	record := &ConversionRecord{Converters: []string{"vnc"}, Warnings: []string{"something ignored"}}
+	publisher.publishResults(vmi, record, &domainSpec, nil)
+
+	events := recordedEvents(recorder)
+	if len(events) != 2 || events[0] != "Normal Converted Applied converters: vnc" || events[1] != "Warning ConversionWarning something ignored" {
+		t.Errorf("Unexpected events, %v", events)
+	}
+	expected := `default/android {"metadata":{"annotations":{"status.droidvirt.io/graphics":"{\"vnc\":5901,\"websocket\":5911}"}}}`
+	if len(client.patches) != 1 || client.patches[0] != expected {
+		t.Errorf("Unexpected VMI patches, %v", client.patches)
+	}
+
+	client.patches = nil
+	publisher.publishResults(vmi, nil, &domainSchema.DomainSpec{}, fmt.Errorf("host device refused"))
+	events = recordedEvents(recorder)
+	if len(events) != 1 || !strings.HasPrefix(events[0], "Warning ConversionFailed") || len(client.patches) != 0 {
+		t.Errorf("Unexpected failure events, %v", events)
+	}
+
+	// events refer to the VMI by its kind
+	var nilPublisher *ResultPublisher
+	nilPublisher.Publish(vmi, record, &domainSpec, nil)
+	publisher.Publish(vmi, nil, &domainSchema.DomainSpec{}, nil)
+	if vmi.GetObjectKind().GroupVersionKind().Kind != "VirtualMachineInstance" {
+		t.Errorf("VMI kind not set, %+v", vmi.GetObjectKind())
+	}
+}
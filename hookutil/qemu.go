@@ -0,0 +1,57 @@
+package hookutil
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const (
+	qemuNamespace  = "http://libvirt.org/schemas/domain/qemu/1.0"
+	qemuVersionEnv = "QEMU_VERSION"
+)
+
+// qemu runs as the qemu user of the compute container, uid and gid 107 in KubeVirt images
+var QEMUUID, QEMUGID = 107, 107
+
+// QEMUVersion is the qemu version of the compute container, e.g. "8.2.0". The sidecar can't ask qemu,
+// so devices of newer qemu releases are only added when it's given
+var QEMUVersion string
+
+// AddQEMUVersionFlag adds --qemu-version, env QEMU_VERSION by default, to flags
+func AddQEMUVersionFlag(flags *pflag.FlagSet) {
+	flags.StringVar(&QEMUVersion, "qemu-version", os.Getenv(qemuVersionEnv), "qemu version of the compute container, e.g. 8.2.0, devices of newer qemu releases are skipped without it")
+}
+
+// QEMUAtLeast reports whether QEMUVersion is given and not older than major.minor
+func QEMUAtLeast(major int, minor int) bool {
+	var actualMajor, actualMinor int
+	if _, err := fmt.Sscanf(strings.TrimSpace(QEMUVersion), "%d.%d", &actualMajor, &actualMinor); err != nil {
+		return false
+	}
+	return actualMajor > major || actualMajor == major && actualMinor >= minor
+}
+
+// AppendQEMUArgs passes args to qemu by the qemu:commandline element, for what the domain schema lacks
+func AppendQEMUArgs(domainSpec *domainSchema.DomainSpec, args ...string) {
+	if domainSpec.XmlNS == "" {
+		domainSpec.XmlNS = qemuNamespace
+	}
+
+	if domainSpec.QEMUCmd == nil {
+		domainSpec.QEMUCmd = &domainSchema.Commandline{}
+	}
+
+	if domainSpec.QEMUCmd.QEMUArg == nil {
+		domainSpec.QEMUCmd.QEMUArg = make([]domainSchema.Arg, 0)
+	}
+
+	for _, arg := range args {
+		domainSpec.QEMUCmd.QEMUArg = append(domainSpec.QEMUCmd.QEMUArg, domainSchema.Arg{
+			Value: arg,
+		})
+	}
+}
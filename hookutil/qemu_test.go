@@ -0,0 +1,23 @@
+package hookutil
+
+import (
+	"testing"
+)
+
+func TestQEMUAtLeast(t *testing.T) {
+	defer func(version string) { QEMUVersion = version }(QEMUVersion)
+	for version, expected := range map[string]bool{
+		"":       false,
+		"8":      false,
+		"latest": false,
+		"7.2.0":  false,
+		"8.0":    true,
+		"8.2.1":  true,
+		"10.0.0": true,
+	} {
+		QEMUVersion = version
+		if QEMUAtLeast(8, 0) != expected {
+			t.Errorf("Unexpected result for qemu %q, expected %v", version, expected)
+		}
+	}
+}
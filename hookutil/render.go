@@ -0,0 +1,178 @@
+package hookutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/pflag"
+	hooksV1alpha1 "kubevirt.io/kubevirt/pkg/hooks/v1alpha1"
+)
+
+// RenderCommand runs the converters on local files instead of serving the hook,
+// e.g. to review annotation changes in CI
+const RenderCommand = "render"
+
+// DryRun keeps the converters from touching the host, e.g. copying NVRAM or reading the node's cpuinfo,
+// so rendering a domain outside of a pod only depends on its inputs
+var DryRun bool
+
+// DomainDefiner is the OnDefineDomain callback of a sidecar
+type DomainDefiner interface {
+	OnDefineDomain(ctx context.Context, params *hooksV1alpha1.OnDefineDomainParams) (*hooksV1alpha1.OnDefineDomainResult, error)
+}
+
+// RunRender parses the render flags into flags, which may hold sidecar flags, then renders the domain
+// by the server newServer returns after parsing
+func RunRender(args []string, flags *pflag.FlagSet, newServer func() DomainDefiner) int {
+	domainPath := flags.String("domain", "", "domain XML file, e.g. from virsh dumpxml")
+	vmiPath := flags.String("vmi", "", "VMI YAML or JSON file")
+	diffOnly := flags.Bool("diff-only", false, "print the unified diff only")
+	AddQEMUVersionFlag(flags)
+	flags.Parse(args)
+
+	if *domainPath == "" || *vmiPath == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s --domain <domain.xml> --vmi <vmi.yaml>\n", os.Args[0], RenderCommand)
+		return 2
+	}
+
+	// the domain is rendered for review, not for a VM on this host
+	DryRun = true
+	if err := RenderDomain(newServer(), *domainPath, *vmiPath, *diffOnly, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to render domain: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// RenderDomain runs the server on the domain and VMI files and writes the result to out
+func RenderDomain(server DomainDefiner, domainPath string, vmiPath string, diffOnly bool, out io.Writer) error {
+	domainXML, err := ioutil.ReadFile(domainPath)
+	if err != nil {
+		return err
+	}
+	vmiData, err := ioutil.ReadFile(vmiPath)
+	if err != nil {
+		return err
+	}
+	vmiJSON, err := yaml.YAMLToJSON(vmiData)
+	if err != nil {
+		return fmt.Errorf("invalid VMI %s: %v", vmiPath, err)
+	}
+
+	result, err := server.OnDefineDomain(context.Background(), &hooksV1alpha1.OnDefineDomainParams{
+		DomainXML: domainXML,
+		Vmi:       vmiJSON,
+	})
+	if err != nil {
+		return err
+	}
+
+	return WriteRendered(domainXML, domainPath, result.GetDomainXML(), diffOnly, out)
+}
+
+// WriteRendered prints the rendered domain unless diffOnly is set, and a unified diff against the input
+func WriteRendered(domainXML []byte, domainPath string, renderedXML []byte, diffOnly bool, out io.Writer) error {
+	// indent both sides the same way, so the diff only shows what the converters changed
+	before, err := IndentXML(domainXML)
+	if err != nil {
+		return fmt.Errorf("invalid domain %s: %v", domainPath, err)
+	}
+	after, err := IndentXML(renderedXML)
+	if err != nil {
+		return err
+	}
+
+	if !diffOnly {
+		fmt.Fprintf(out, "%s\n", after)
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(before) + "\n"),
+		B:        difflib.SplitLines(string(after) + "\n"),
+		FromFile: domainPath,
+		ToFile:   "rendered",
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(out, diff)
+	return nil
+}
+
+// IndentXML puts every element on its own line. Raw tokens keep namespace prefixes like
+// qemu:commandline as written, which an xml.Encoder would rewrite.
+func IndentXML(data []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	buf := &bytes.Buffer{}
+	depth := 0
+	// whether the last element written is still open without child elements
+	inline := false
+
+	newline := func() {
+		if buf.Len() > 0 {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(strings.Repeat("  ", depth))
+	}
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			newline()
+			buf.WriteString("<" + xmlName(t.Name))
+			for _, attr := range t.Attr {
+				buf.WriteString(" " + xmlName(attr.Name) + `="`)
+				xml.EscapeText(buf, []byte(attr.Value))
+				buf.WriteString(`"`)
+			}
+			buf.WriteString(">")
+			depth++
+			inline = true
+		case xml.EndElement:
+			depth--
+			if !inline {
+				newline()
+			}
+			buf.WriteString("</" + xmlName(t.Name) + ">")
+			inline = false
+		case xml.CharData:
+			if text := bytes.TrimSpace(t); len(text) > 0 {
+				xml.EscapeText(buf, text)
+			}
+		case xml.Comment:
+			newline()
+			buf.WriteString("<!--" + string(t) + "-->")
+			inline = false
+		case xml.ProcInst:
+			newline()
+			buf.WriteString("<?" + t.Target + " " + string(t.Inst) + "?>")
+		case xml.Directive:
+			newline()
+			buf.WriteString("<!" + string(t) + ">")
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
@@ -0,0 +1,71 @@
+package hookutil
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	hooksV1alpha1 "kubevirt.io/kubevirt/pkg/hooks/v1alpha1"
+)
+
+func TestIndentXML(t *testing.T) {
+	domainXML := `<domain xmlns:qemu="http://libvirt.org/schemas/domain/qemu/1.0"><name>osx</name>` +
+		`<qemu:commandline><qemu:arg value="a&amp;b"></qemu:arg></qemu:commandline></domain>`
+	expected := `<domain xmlns:qemu="http://libvirt.org/schemas/domain/qemu/1.0">
+  <name>osx</name>
+  <qemu:commandline>
+    <qemu:arg value="a&amp;b"></qemu:arg>
+  </qemu:commandline>
+</domain>`
+
+	indented, err := IndentXML([]byte(domainXML))
+	if err != nil {
+		t.Fatalf("Failed to indent XML: %v", err)
+	}
+	if string(indented) != expected {
+		t.Errorf("Unexpected XML, %s", indented)
+	}
+}
+
+func TestWriteRendered(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := WriteRendered([]byte(`<domain><name>osx</name></domain>`), "domain.xml", []byte(`<domain><name>osx</name><vcpu>2</vcpu></domain>`), true, out); err != nil {
+		t.Fatalf("Failed to write rendered domain: %v", err)
+	}
+	if diff := out.String(); !strings.HasPrefix(diff, "--- domain.xml\n+++ rendered\n") || !strings.Contains(diff, "\n+  <vcpu>2</vcpu>\n") {
+		t.Errorf("Unexpected diff, %s", diff)
+	}
+}
+
+type vmiDescriber struct{}
+
+// OnDefineDomain puts the VMI JSON into the domain description
+func (vmiDescriber) OnDefineDomain(ctx context.Context, params *hooksV1alpha1.OnDefineDomainParams) (*hooksV1alpha1.OnDefineDomainResult, error) {
+	domainXML := strings.Replace(string(params.GetDomainXML()), "</name>", "</name><description>"+string(params.GetVmi())+"</description>", 1)
+	return &hooksV1alpha1.OnDefineDomainResult{DomainXML: []byte(domainXML)}, nil
+}
+
+func TestRenderDomain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatalf("Failed to create render dir")
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(dir+"/domain.xml", []byte(`<domain><name>osx</name></domain>`), 0644)
+	ioutil.WriteFile(dir+"/vmi.yaml", []byte("kind: VirtualMachineInstance\n"), 0644)
+
+	out := &bytes.Buffer{}
+	if err := RenderDomain(vmiDescriber{}, dir+"/domain.xml", dir+"/vmi.yaml", true, out); err != nil {
+		t.Fatalf("Failed to render domain: %v", err)
+	}
+	if diff := out.String(); !strings.Contains(diff, "\n+  <description>{&#34;kind&#34;:&#34;VirtualMachineInstance&#34;}</description>\n") {
+		t.Errorf("Unexpected diff, %s", diff)
+	}
+
+	if err := RenderDomain(vmiDescriber{}, dir+"/domain.xml", dir+"/missing.yaml", true, out); err == nil {
+		t.Errorf("Missing VMI file not reported")
+	}
+}
@@ -0,0 +1,38 @@
+package hookutil
+
+import (
+	"fmt"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+)
+
+// Warnings are the parts of the annotations a converter rejected and skipped, while it still
+// applies the others. Converters return them as error, see ConversionRecord.Apply.
+type Warnings []string
+
+func (w Warnings) Error() string {
+	return strings.Join(w, "; ")
+}
+
+// Addf logs the rejected annotation and keeps it
+func (w *Warnings) Addf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	log.Log.Error(warning)
+	*w = append(*w, warning)
+}
+
+// Err returns nil when nothing was rejected
+func (w Warnings) Err() error {
+	if len(w) == 0 {
+		return nil
+	}
+	return w
+}
+
+// Warnf logs and returns a single rejected annotation
+func Warnf(format string, args ...interface{}) error {
+	var warnings Warnings
+	warnings.Addf(format, args...)
+	return warnings
+}
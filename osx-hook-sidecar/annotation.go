@@ -2,12 +2,41 @@ package main
 
 const (
 	converterType    = "converter.droidvirt.io/type"
+	profileName      = "profile.droidvirt.io/name" // profile file providing the other annotations
 	vncPort          = "vnc.droidvirt.io/port"
 	vncWebsocketPort = "websocket.vnc.droidvirt.io/port"
 	diskNames        = "disk.droidvirt.io/names" // split name by comma
 	diskDriver       = "disk.droidvirt.io/driverType"
+	nicModel         = "nic.droidvirt.io/models"    // alias:model pairs split by comma
+	inputDevices     = "input.droidvirt.io/devices" // type:bus pairs split by comma
+	inputController  = "input.droidvirt.io/usbController"
+	usbControllers   = "usb.droidvirt.io/controllers" // index:model pairs split by comma
+	usbPorts         = "usb.droidvirt.io/ports"       // applies to every qemu-xhci controller
+	vcpuPin          = "cputune.droidvirt.io/vcpupin" // vcpu:cpuset pairs split by semicolon, or auto
+	emulatorPin      = "cputune.droidvirt.io/emulatorpin"
+	ioThreadPin      = "cputune.droidvirt.io/iothreadpin" // iothread:cpuset pairs split by semicolon
+	cpuSetPath       = "cputune.droidvirt.io/cpusetPath"  // cpuset file auto pinning reads
+	numaMemory       = "numatune.droidvirt.io/memory"     // mode:nodeset
+	hypervFeatures   = "features.droidvirt.io/hyperv"     // enlightenments split by comma, -name turns one off
+	kvmHidden        = "features.droidvirt.io/kvmHidden"
+	vmport           = "features.droidvirt.io/vmport"
+	clockOffset      = "clock.droidvirt.io/offset" // utc or localtime
+	hpetTimer        = "clock.droidvirt.io/hpet"
+	tscFrequency     = "clock.droidvirt.io/tscFrequency" // Hz
 	loaderPath       = "loader.osx-kvm.io/path"
 	nvramPath        = "nvram.osx-kvm.io/path"
+	loaderDigest     = "loader.osx-kvm.io/sha256"      // expected digest of the loader file
+	nvramDigest      = "nvram.osx-kvm.io/sha256"       // expected digest of the nvram template
+	bootProfileName  = "profile.osx-kvm.io/bootloader" // clover, opencore or opencore-ventura
+	cpuModel         = "cpu.osx-kvm.io/model"
+	cpuFeatures      = "cpu.osx-kvm.io/features" // qemu -cpu flags split by comma
+	cpuVendor        = "cpu.osx-kvm.io/vendor"   // node cpu vendor, e.g. AuthenticAMD
+	boardPath        = "board.osx-kvm.io/path"   // board definition file mounted into the sidecar
+	smbiosProduct    = "smbios.osx-kvm.io/product"
+	smbiosSerial     = "smbios.osx-kvm.io/serial"
+	smbiosMLB        = "smbios.osx-kvm.io/mlb" // board serial
+	smbiosUUID       = "smbios.osx-kvm.io/uuid"
+	smbiosROM        = "smbios.osx-kvm.io/rom"
 )
 
 type ConverterType string
@@ -19,4 +48,12 @@ const (
 	BootLoaderConverter  ConverterType = "boot-loader"
 	NICModelConverter    ConverterType = "nic-model"
 	InputDeviceConverter ConverterType = "input-device"
+	DiskBusConverter     ConverterType = "disk-bus"
+	SMBiosConverter      ConverterType = "smbios"
+	NICOptionsConverter  ConverterType = "nic-options"
+	USBConverter         ConverterType = "usb-controller"
+	AudioConverter       ConverterType = "audio"
+	CPUTuneConverter     ConverterType = "cpu-tune"
+	FeaturesConverter    ConverterType = "features"
+	FirmwareConverter    ConverterType = "firmware"
 )
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// board definition mounted into the sidecar, e.g. from a ConfigMap
+const defaultBoardPath = "/etc/osx-hook-sidecar/board.json"
+
+type boardDefinition struct {
+	// Apple SMC key, not shipped with the sidecar image
+	OSK string `json:"osk"`
+	// qemu args replacing the isa-applesmc and smbios defaults, "$OSK" expands to the key
+	Args []string `json:"args,omitempty"`
+}
+
+func loadBoardDefinition(annotations map[string]string) (*boardDefinition, error) {
+	path, found := annotations[boardPath]
+	if !found {
+		path = defaultBoardPath
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	board := &boardDefinition{}
+	if err := json.Unmarshal(data, board); err != nil {
+		return nil, fmt.Errorf("invalid board definition %s: %v", path, err)
+	}
+	if board.OSK == "" && len(board.Args) == 0 {
+		return nil, fmt.Errorf("board definition %s has neither osk nor args", path)
+	}
+	return board, nil
+}
+
+func (b *boardDefinition) qemuArgs(profile bootProfile) []string {
+	if len(b.Args) == 0 {
+		return []string{
+			"-device",
+			"isa-applesmc,osk=" + b.OSK,
+			"-smbios",
+			profile.smbiosType,
+		}
+	}
+
+	args := make([]string, 0, len(b.Args))
+	for _, arg := range b.Args {
+		args = append(args, strings.Replace(arg, "$OSK", b.OSK, -1))
+	}
+	return args
+}
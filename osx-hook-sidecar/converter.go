@@ -6,16 +6,46 @@ import (
 	"strings"
 
 	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
 	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
 )
 
 const (
 	vncBindAddress    = "0.0.0.0"
 	defaultDiskDriver = "qcow2"
+	defaultNicModel   = "vmxnet3"
+
+	defaultInputDevices  = "keyboard:ps2,mouse:ps2,tablet:usb,keyboard:usb"
+	defaultUSBController = "piix3-uhci"
 )
 
-func addBootLoader(annotations map[string]string, domainSpec *domainSchema.DomainSpec) {
-	if loaderPath, found := annotations[loaderPath]; found {
+// buses each input type can be attached to
+var inputBuses = map[string][]string{
+	"keyboard":   {"ps2", "usb", "virtio"},
+	"mouse":      {"ps2", "usb", "virtio"},
+	"tablet":     {"usb", "virtio"},
+	"multitouch": {"virtio"},
+}
+
+var supportedNicModels = map[string]bool{
+	"vmxnet3": true,
+	"e1000":   true,
+	"e1000e":  true,
+	"virtio":  true,
+	"rtl8139": true,
+}
+
+func addBootLoader(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	profile, err := getBootProfile(annotations)
+	if err != nil {
+		return err
+	}
+
+	loaderPath, found := annotations[loaderPath]
+	if !found {
+		loaderPath = profile.loaderPath
+	}
+	if loaderPath != "" {
 		domainSpec.OS.BootLoader = &domainSchema.Loader{
 			Path:     loaderPath,
 			ReadOnly: "yes",
@@ -23,83 +53,121 @@ func addBootLoader(annotations map[string]string, domainSpec *domainSchema.Domai
 			Type:     "pflash",
 		}
 	}
-	if nvramPath, found := annotations[nvramPath]; found {
+
+	nvramPath, found := annotations[nvramPath]
+	if !found {
+		nvramPath = profile.nvramPath
+	}
+	if nvramPath != "" {
 		domainSpec.OS.NVRam = &domainSchema.NVRam{
 			NVRam: nvramPath,
 		}
+		// profile files and files given with a storage volume are only the template of the VM's own copy,
+		// VMs sharing them would write the same variable store
+		if _, storage := annotations[hookutil.NVRamStorageAnnotation]; !found || storage {
+			if err := hookutil.SetNVRamCopy(annotations, nvramPath, domainSpec); err != nil {
+				return fmt.Errorf("failed to copy nvram template %s: %v", nvramPath, err)
+			}
+		}
 	}
+	return nil
 }
 
-func addInputDevice(domainSpec *domainSchema.DomainSpec) {
-	inputDevices := make([]domainSchema.Input, 0)
-
-	inputDevices = append(inputDevices, domainSchema.Input{
-		Type: "keyboard",
-		Bus:  "ps2",
-	})
-
-	inputDevices = append(inputDevices, domainSchema.Input{
-		Type: "mouse",
-		Bus:  "ps2",
-	})
+func addInputDevice(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	devicesStr, found := annotations[inputDevices]
+	if !found {
+		devicesStr = defaultInputDevices
+	}
 
-	inputDevices = append(inputDevices, domainSchema.Input{
-		Type: "tablet",
-		Bus:  "usb",
-	})
+	var warnings hookutil.Warnings
+	needUSB := false
+	for _, device := range strings.Split(devicesStr, ",") {
+		kv := strings.SplitN(strings.TrimSpace(device), ":", 2)
+		if len(kv) != 2 || !supportedInputBus(kv[0], kv[1]) {
+			warnings.Addf("Unsupported input device: %s", device)
+			continue
+		}
+		inputType, bus := kv[0], kv[1]
 
-	inputDevices = append(inputDevices, domainSchema.Input{
-		Type: "keyboard",
-		Bus:  "usb",
-	})
+		if inputType == "multitouch" {
+			// libvirt has no multitouch input, qemu provides a virtio one since 8.0
+			if !hookutil.QEMUAtLeast(8, 0) {
+				warnings.Addf("Multitouch input needs QEMU 8.0 or later, set by --qemu-version: %s", device)
+				continue
+			}
+			hookutil.AppendQEMUArgs(domainSpec, "-device", "virtio-multitouch-pci")
+			continue
+		}
 
-	domainSpec.Devices.Inputs = inputDevices
+		if bus == "usb" {
+			needUSB = true
+		}
 
-	for idx, ctrl := range domainSpec.Devices.Controllers {
-		if ctrl.Type == "usb" && ctrl.Model == "none" {
-			domainSpec.Devices.Controllers = append(domainSpec.Devices.Controllers[:idx], domainSpec.Devices.Controllers[idx+1:]...)
-			break
+		exists := false
+		for _, input := range domainSpec.Devices.Inputs {
+			if input.Type == inputType && input.Bus == bus {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			domainSpec.Devices.Inputs = append(domainSpec.Devices.Inputs, domainSchema.Input{
+				Type: inputType,
+				Bus:  bus,
+			})
 		}
 	}
 
-	domainSpec.Devices.Controllers = append(domainSpec.Devices.Controllers, domainSchema.Controller{
-		Type:  "usb",
-		Index: "0",
-		Model: "piix3-uhci",
-	})
+	if needUSB {
+		setUSBController(annotations, domainSpec, &warnings)
+	}
+	return warnings.Err()
 }
 
-func convertBoardType(domainSpec *domainSchema.DomainSpec) {
-	log.Log.Info("Set options in XML 'qemu:commandline'")
-	if domainSpec.XmlNS == "" {
-		domainSpec.XmlNS = "http://libvirt.org/schemas/domain/qemu/1.0"
+func supportedInputBus(inputType string, bus string) bool {
+	for _, supported := range inputBuses[inputType] {
+		if bus == supported {
+			return true
+		}
 	}
+	return false
+}
 
-	if domainSpec.QEMUCmd == nil {
-		domainSpec.QEMUCmd = &domainSchema.Commandline{}
+func setUSBController(annotations map[string]string, domainSpec *domainSchema.DomainSpec, warnings *hookutil.Warnings) {
+	model, found := annotations[inputController]
+	if !found {
+		model = defaultUSBController
+	} else if model != "qemu-xhci" && model != "piix3-uhci" {
+		warnings.Addf("Unsupported USB controller: %s", model)
+		model, found = defaultUSBController, false
 	}
 
-	if domainSpec.QEMUCmd.QEMUArg == nil {
-		domainSpec.QEMUCmd.QEMUArg = make([]domainSchema.Arg, 0)
+	// keep the controller KubeVirt defined unless asked otherwise
+	setUSBControllerModel(domainSpec, "0", model, found)
+}
+
+func convertBoardType(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	profile, err := getBootProfile(annotations)
+	if err != nil {
+		return err
 	}
 
-	args := []string{
-		"-device",
-		"isa-applesmc,osk=ourhardworkbythesewordsguardedpleasedontsteal(c)AppleComputerInc",
-		"-smbios",
-		"type=2",
-		"-cpu",
-		"Penryn,kvm=on,vendor=GenuineIntel,+invtsc,vmware-cpuid-freq=on,+pcid,+ssse3,+sse4.2,+popcnt,+avx,+aes,+xsave,+xsaveopt,check",
+	// macOS doesn't boot without the Apple SMC device, so refuse the domain rather than define it without
+	board, err := loadBoardDefinition(annotations)
+	if err != nil {
+		return fmt.Errorf("failed to load board definition: %v", err)
 	}
 
-	for _, arg := range args {
-		domainSpec.QEMUCmd.QEMUArg = append(domainSpec.QEMUCmd.QEMUArg, domainSchema.Arg{
-			Value: arg,
-		})
+	log.Log.Info("Set options in XML 'qemu:commandline'")
+	args := board.qemuArgs(profile)
+	if cpuArg := convertCPUModel(annotations, profile, domainSpec); cpuArg != "" {
+		args = append(args, "-cpu", cpuArg)
 	}
+	hookutil.AppendQEMUArgs(domainSpec, args...)
+	return nil
 }
 
-func addVncQEMUArgs(annotations map[string]string, domainSpec *domainSchema.DomainSpec) {
+func addVncQEMUArgs(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
 	var heads uint = 1
 	var ram uint = 65536
 	var vram uint = 65536
@@ -119,8 +187,7 @@ func addVncQEMUArgs(annotations map[string]string, domainSpec *domainSchema.Doma
 	if vncPortStr, found := annotations[vncPort]; found {
 		vncPort, err := strconv.ParseInt(vncPortStr, 10, 32)
 		if err != nil || vncPort < 5900 {
-			log.Log.Errorf("Invalid VNC Port: %s", vncPortStr)
-			return
+			return hookutil.Warnf("Invalid VNC Port: %s", vncPortStr)
 		}
 
 		if wsPortStr, found := annotations[vncWebsocketPort]; !found {
@@ -138,8 +205,7 @@ func addVncQEMUArgs(annotations map[string]string, domainSpec *domainSchema.Doma
 		} else {
 			wsPort, err := strconv.ParseInt(wsPortStr, 10, 32)
 			if err != nil || wsPort < 5900 || wsPort == vncPort {
-				log.Log.Errorf("Invalid WebSocket Port: %s", wsPortStr)
-				return
+				return hookutil.Warnf("Invalid WebSocket Port: %s", wsPortStr)
 			}
 
 			log.Log.Info("VNC WebSocket. Set options in XML 'qemu:commandline'")
@@ -164,9 +230,10 @@ func addVncQEMUArgs(annotations map[string]string, domainSpec *domainSchema.Doma
 			})
 		}
 	}
+	return nil
 }
 
-func convertDiskOptions(annotations map[string]string, domainSpec *domainSchema.DomainSpec) {
+func convertDiskOptions(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
 	// change data disk driver type: qcow2
 	if diskNames, found := annotations[diskNames]; found {
 		driverType := annotations[diskDriver]
@@ -188,16 +255,61 @@ func convertDiskOptions(annotations map[string]string, domainSpec *domainSchema.
 			}
 		}
 	}
+	return nil
 }
 
-func convertNicModel(domainSpec *domainSchema.DomainSpec) {
+func convertNicModel(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
 	if domainSpec.Devices.Interfaces == nil {
-		return
+		return nil
+	}
+
+	// change nic model, e.g. "default:vmxnet3,net1:e1000e", an entry without alias applies to all
+	var warnings hookutil.Warnings
+	defaultModel := defaultNicModel
+	models := make(map[string]string)
+	if modelStr, found := annotations[nicModel]; found {
+		defaultModel = ""
+		for _, entry := range strings.Split(modelStr, ",") {
+			name, model := "", strings.TrimSpace(entry)
+			if kv := strings.SplitN(entry, ":", 2); len(kv) == 2 {
+				name, model = strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			}
+			if !supportedNicModels[model] {
+				warnings.Addf("Unsupported NIC model: %s", entry)
+				continue
+			}
+			if name == "" {
+				defaultModel = model
+			} else {
+				models[name] = model
+			}
+		}
 	}
 
-	for _, nicDevice := range domainSpec.Devices.Interfaces {
-		if nicDevice.Model != nil && nicDevice.Model.Type != "vmxnet3" {
-			nicDevice.Model.Type = "vmxnet3"
+	for idx, nicDevice := range domainSpec.Devices.Interfaces {
+		model := defaultModel
+		if nicDevice.Alias != nil {
+			if aliasModel, found := models[nicDevice.Alias.Name]; found {
+				model = aliasModel
+			}
+		}
+		if model == "" {
+			continue
+		}
+
+		nic := &domainSpec.Devices.Interfaces[idx]
+		if nic.Model == nil {
+			nic.Model = &domainSchema.Model{}
+		}
+		if nic.Model.Type == model {
+			continue
+		}
+		nic.Model.Type = model
+		if model != "virtio" {
+			// vhost driver and queues are virtio only
+			nic.Driver = nil
 		}
+		log.Log.Infof("NIC %d model changed to %s", idx, model)
 	}
+	return warnings.Err()
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const (
+	intelVendor = "GenuineIntel"
+	amdVendor   = "AuthenticAMD"
+)
+
+// containers share cpuinfo with the node, so it tells which vendor the guest will run on. This assumes the
+// sidecar sees the node's cpuinfo, which sandboxed runtimes like Kata or gVisor don't give; cpuVendor overrides it.
+var cpuInfoPath = "/proc/cpuinfo"
+
+// flags missing on most AMD hosts, dropped from the profile defaults there
+var intelOnlyFeatures = map[string]bool{
+	"+pcid": true,
+}
+
+func hostCPUVendor(annotations map[string]string) string {
+	if vendor, found := annotations[cpuVendor]; found {
+		return vendor
+	}
+	if hookutil.DryRun {
+		// unknown without the node, the profile flags are kept as they are
+		return ""
+	}
+	file, err := os.Open(cpuInfoPath)
+	if err != nil {
+		log.Log.Reason(err).Errorf("Failed to read host cpu vendor from %s", cpuInfoPath)
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "vendor_id" {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+// convertCPUModel renders the board cpu into domainSpec.CPU when libvirt can express every flag,
+// otherwise it returns the value for a qemu -cpu arg.
+func convertCPUModel(annotations map[string]string, profile bootProfile, domainSpec *domainSchema.DomainSpec) string {
+	vendor := hostCPUVendor(annotations)
+
+	model := profile.cpuModel
+	if modelStr, found := annotations[cpuModel]; found {
+		model = modelStr
+	}
+
+	features := splitCPUFeatures(profile.cpuFeatures)
+	if featuresStr, found := annotations[cpuFeatures]; found {
+		features = splitCPUFeatures(featuresStr)
+	} else if vendor == amdVendor {
+		defaults := make([]string, 0)
+		for _, feature := range features {
+			if !intelOnlyFeatures[feature] {
+				defaults = append(defaults, feature)
+			}
+		}
+		features = defaults
+	}
+
+	// the guest reads a fixed tsc frequency from the clock timer instead of the vmware cpuid leaf
+	if fixedTSCFrequency(annotations) {
+		kept := make([]string, 0, len(features))
+		for _, feature := range features {
+			if !strings.HasPrefix(feature, "vmware-cpuid-freq=") {
+				kept = append(kept, feature)
+			}
+		}
+		features = kept
+	}
+
+	// macOS only boots on an Intel vendor, which libvirt can not spoof
+	if vendor != "" && vendor != intelVendor {
+		hasVendor := false
+		for _, feature := range features {
+			if strings.HasPrefix(feature, "vendor=") {
+				hasVendor = true
+			}
+		}
+		if !hasVendor {
+			features = append(features, "vendor="+intelVendor)
+		}
+	}
+
+	cpuFeatures := make([]domainSchema.CPUFeature, 0)
+	qemuOnly := false
+	for _, feature := range features {
+		switch {
+		case feature == "check" || feature == "kvm=on":
+			// libvirt defaults
+		case feature == "vendor="+intelVendor && vendor == intelVendor:
+			// already the host vendor
+		case strings.HasPrefix(feature, "+"):
+			cpuFeatures = append(cpuFeatures, domainSchema.CPUFeature{
+				Name:   feature[1:],
+				Policy: "require",
+			})
+		case strings.HasPrefix(feature, "-"):
+			cpuFeatures = append(cpuFeatures, domainSchema.CPUFeature{
+				Name:   feature[1:],
+				Policy: "disable",
+			})
+		default:
+			// qemu properties like vmware-cpuid-freq=on
+			qemuOnly = true
+		}
+	}
+
+	if qemuOnly {
+		log.Log.Infof("CPU %s has qemu only flags, set options in XML 'qemu:commandline'", model)
+		return strings.Join(append([]string{model}, features...), ",")
+	}
+
+	log.Log.Infof("Set CPU %s in XML 'cpu'", model)
+	domainSpec.CPU.Mode = "custom"
+	domainSpec.CPU.Model = model
+	domainSpec.CPU.Features = cpuFeatures
+	return ""
+}
+
+func splitCPUFeatures(featuresStr string) []string {
+	features := make([]string, 0)
+	for _, feature := range strings.Split(featuresStr, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
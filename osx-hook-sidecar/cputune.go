@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+// cgroup v1 cpuset of the container, mount the compute container's one by the injector to pin on it
+const defaultCPUSetPath = "/sys/fs/cgroup/cpuset/cpuset.cpus"
+
+var cpuSetFormat = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+
+func convertCPUTune(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	var warnings hookutil.Warnings
+	cpuTune := domainSpec.CPUTune
+	if cpuTune == nil {
+		cpuTune = &domainSchema.CPUTune{}
+	}
+
+	if pins, found := annotations[vcpuPin]; found {
+		if pins == "auto" {
+			if err := autoPinVCPUs(annotations, domainSpec, cpuTune); err != nil {
+				warnings.Addf("Failed to pin vcpus on the container cpuset: %v", err)
+			}
+		} else {
+			cpuTune.VCPUPin = make([]domainSchema.CPUTuneVCPUPin, 0)
+			for id, cpuSet := range parseCPUSetPairs(pins, &warnings) {
+				cpuTune.VCPUPin = append(cpuTune.VCPUPin, domainSchema.CPUTuneVCPUPin{
+					VCPU:   id,
+					CPUSet: cpuSet,
+				})
+			}
+			sort.Slice(cpuTune.VCPUPin, func(i, j int) bool {
+				return cpuTune.VCPUPin[i].VCPU < cpuTune.VCPUPin[j].VCPU
+			})
+		}
+	}
+
+	if cpuSet, found := annotations[emulatorPin]; found {
+		if !cpuSetFormat.MatchString(cpuSet) {
+			warnings.Addf("Invalid emulator cpuset: %s", cpuSet)
+		} else {
+			cpuTune.EmulatorPin = &domainSchema.CPUEmulatorPin{CPUSet: cpuSet}
+		}
+	}
+
+	if pins, found := annotations[ioThreadPin]; found {
+		cpuTune.IOThreadPin = make([]domainSchema.CPUTuneIOThreadPin, 0)
+		for id, cpuSet := range parseCPUSetPairs(pins, &warnings) {
+			cpuTune.IOThreadPin = append(cpuTune.IOThreadPin, domainSchema.CPUTuneIOThreadPin{
+				IOThread: id,
+				CPUSet:   cpuSet,
+			})
+		}
+		sort.Slice(cpuTune.IOThreadPin, func(i, j int) bool {
+			return cpuTune.IOThreadPin[i].IOThread < cpuTune.IOThreadPin[j].IOThread
+		})
+	}
+
+	if len(cpuTune.VCPUPin) != 0 || len(cpuTune.IOThreadPin) != 0 || cpuTune.EmulatorPin != nil {
+		domainSpec.CPUTune = cpuTune
+	}
+
+	// mode:nodeset, e.g. "strict:0"
+	if numaStr, found := annotations[numaMemory]; found {
+		kv := strings.SplitN(numaStr, ":", 2)
+		if len(kv) != 2 || !cpuSetFormat.MatchString(kv[1]) ||
+			(kv[0] != "strict" && kv[0] != "preferred" && kv[0] != "interleave") {
+			warnings.Addf("Invalid NUMA memory tune: %s", numaStr)
+			return warnings.Err()
+		}
+		if domainSpec.NUMATune == nil {
+			domainSpec.NUMATune = &domainSchema.NUMATune{}
+		}
+		domainSpec.NUMATune.Memory = domainSchema.NumaTuneMemory{
+			Mode:    kv[0],
+			NodeSet: kv[1],
+		}
+	}
+	return warnings.Err()
+}
+
+// parseCPUSetPairs parses id:cpuset pairs split by semicolon, e.g. "0:2;1:3-4"
+func parseCPUSetPairs(pairs string, warnings *hookutil.Warnings) map[uint]string {
+	cpuSets := make(map[uint]string)
+	for _, pair := range strings.Split(pairs, ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(kv) != 2 {
+			warnings.Addf("Invalid cpuset pair: %s", pair)
+			continue
+		}
+		id, err := strconv.ParseUint(kv[0], 10, 32)
+		if err != nil || !cpuSetFormat.MatchString(kv[1]) {
+			warnings.Addf("Invalid cpuset pair: %s", pair)
+			continue
+		}
+		cpuSets[uint(id)] = kv[1]
+	}
+	return cpuSets
+}
+
+// autoPinVCPUs pins each vcpu on its own cpu of the container cpuset, the emulator on the rest
+func autoPinVCPUs(annotations map[string]string, domainSpec *domainSchema.DomainSpec, cpuTune *domainSchema.CPUTune) error {
+	if hookutil.DryRun {
+		return fmt.Errorf("the cpuset of the container is only known in the pod")
+	}
+	path, found := annotations[cpuSetPath]
+	if !found {
+		path = defaultCPUSetPath
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cpus, err := parseCPUSet(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+
+	vcpus := 0
+	if domainSpec.VCPU != nil {
+		vcpus = int(domainSpec.VCPU.CPUs)
+	} else if topology := domainSpec.CPU.Topology; topology != nil {
+		vcpus = int(topology.Sockets * topology.Cores * topology.Threads)
+	}
+	if vcpus == 0 || vcpus > len(cpus) {
+		return fmt.Errorf("can not pin %d vcpus on cpuset %s", vcpus, path)
+	}
+
+	cpuTune.VCPUPin = make([]domainSchema.CPUTuneVCPUPin, 0, vcpus)
+	for idx := 0; idx < vcpus; idx++ {
+		cpuTune.VCPUPin = append(cpuTune.VCPUPin, domainSchema.CPUTuneVCPUPin{
+			VCPU:   uint(idx),
+			CPUSet: strconv.Itoa(cpus[idx]),
+		})
+	}
+
+	emulatorCPUs := cpus[vcpus:]
+	if len(emulatorCPUs) == 0 {
+		emulatorCPUs = cpus
+	}
+	cpuSet := make([]string, 0, len(emulatorCPUs))
+	for _, cpu := range emulatorCPUs {
+		cpuSet = append(cpuSet, strconv.Itoa(cpu))
+	}
+	cpuTune.EmulatorPin = &domainSchema.CPUEmulatorPin{CPUSet: strings.Join(cpuSet, ",")}
+	return nil
+}
+
+func parseCPUSet(cpuSet string) ([]int, error) {
+	if !cpuSetFormat.MatchString(cpuSet) {
+		return nil, fmt.Errorf("invalid cpuset: %s", cpuSet)
+	}
+
+	cpus := make([]int, 0)
+	for _, part := range strings.Split(cpuSet, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, _ := strconv.Atoi(bounds[0])
+		last := first
+		if len(bounds) == 2 {
+			last, _ = strconv.Atoi(bounds[1])
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
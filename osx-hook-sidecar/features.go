@@ -0,0 +1,177 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const defaultSpinlockRetries uint32 = 8191
+
+func convertFeatures(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	var warnings hookutil.Warnings
+	features := domainSpec.Features
+	if features == nil {
+		features = &domainSchema.Features{}
+	}
+
+	// enlightenments split by comma, "-name" turns one off
+	if hypervStr, found := annotations[hypervFeatures]; found {
+		if features.Hyperv == nil {
+			features.Hyperv = &domainSchema.FeatureHyperv{}
+		}
+		for _, name := range strings.Split(hypervStr, ",") {
+			name = strings.TrimSpace(name)
+			state := "on"
+			if strings.HasPrefix(name, "-") {
+				name, state = name[1:], "off"
+			}
+			if !setHypervFeature(features.Hyperv, name, state) {
+				warnings.Addf("Unsupported hyperv feature: %s", name)
+			}
+		}
+	}
+
+	if hiddenStr, found := annotations[kvmHidden]; found {
+		hidden, err := strconv.ParseBool(hiddenStr)
+		if err != nil {
+			warnings.Addf("Invalid kvm hidden state: %s", hiddenStr)
+		} else {
+			features.KVM = &domainSchema.FeatureKVM{
+				Hidden: &domainSchema.FeatureState{State: onOff(hidden)},
+			}
+		}
+	}
+
+	if vmportStr, found := annotations[vmport]; found {
+		enabled, err := strconv.ParseBool(vmportStr)
+		if err != nil {
+			warnings.Addf("Invalid vmport: %s", vmportStr)
+		} else {
+			// domain schema has no vmport feature, qemu merges machine options
+			hookutil.AppendQEMUArgs(domainSpec, "-machine", "vmport="+onOff(enabled))
+		}
+	}
+
+	if features.Hyperv != nil || features.KVM != nil {
+		domainSpec.Features = features
+	}
+
+	convertClock(annotations, domainSpec, &warnings)
+	return warnings.Err()
+}
+
+func convertClock(annotations map[string]string, domainSpec *domainSchema.DomainSpec, warnings *hookutil.Warnings) {
+	clock := domainSpec.Clock
+	if clock == nil {
+		clock = &domainSchema.Clock{}
+	}
+
+	if offset, found := annotations[clockOffset]; found {
+		if offset != "utc" && offset != "localtime" {
+			warnings.Addf("Unsupported clock offset: %s", offset)
+		} else {
+			clock.Offset = offset
+		}
+	}
+
+	if hpetStr, found := annotations[hpetTimer]; found {
+		present, err := strconv.ParseBool(hpetStr)
+		if err != nil {
+			warnings.Addf("Invalid hpet timer: %s", hpetStr)
+		} else {
+			timer := domainSchema.Timer{Name: "hpet", Present: "no"}
+			if present {
+				timer.Present = "yes"
+			}
+			clock.Timer = setTimer(clock.Timer, timer)
+		}
+	}
+
+	if frequency, found := annotations[tscFrequency]; found {
+		if !validTSCFrequency(frequency) {
+			warnings.Addf("Invalid tsc frequency: %s", frequency)
+		} else {
+			// a fixed tsc frequency replaces probing it, the board drops vmware-cpuid-freq then
+			clock.Timer = setTimer(clock.Timer, domainSchema.Timer{Name: "tsc", Present: "yes", Frequency: frequency})
+		}
+	}
+
+	if clock.Offset != "" || len(clock.Timer) != 0 {
+		domainSpec.Clock = clock
+	}
+}
+
+func setHypervFeature(hyperv *domainSchema.FeatureHyperv, name string, state string) bool {
+	featureState := &domainSchema.FeatureState{State: state}
+	switch name {
+	case "relaxed":
+		hyperv.Relaxed = featureState
+	case "vapic":
+		hyperv.VAPIC = featureState
+	case "spinlocks":
+		retries := defaultSpinlockRetries
+		hyperv.Spinlocks = &domainSchema.FeatureSpinlocks{State: state}
+		if state == "on" {
+			hyperv.Spinlocks.Retries = &retries
+		}
+	case "vpindex":
+		hyperv.VPIndex = featureState
+	case "runtime":
+		hyperv.Runtime = featureState
+	case "synic":
+		hyperv.SyNIC = featureState
+	case "stimer":
+		hyperv.SyNICTimer = featureState
+	case "reset":
+		hyperv.Reset = featureState
+	case "frequencies":
+		hyperv.Frequencies = featureState
+	case "reenlightenment":
+		hyperv.Reenlightenment = featureState
+	case "tlbflush":
+		hyperv.TLBFlush = featureState
+	case "ipi":
+		hyperv.IPI = featureState
+	case "evmcs":
+		hyperv.EVMCS = featureState
+	default:
+		return false
+	}
+	return true
+}
+
+func setTimer(timers []domainSchema.Timer, timer domainSchema.Timer) []domainSchema.Timer {
+	for idx := range timers {
+		if timers[idx].Name == timer.Name {
+			timers[idx] = timer
+			return timers
+		}
+	}
+	return append(timers, timer)
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func validTSCFrequency(frequency string) bool {
+	hz, err := strconv.ParseUint(frequency, 10, 64)
+	return err == nil && hz != 0
+}
+
+// fixedTSCFrequency reports whether the features converter sets a tsc timer of a fixed frequency,
+// whatever order the converters run in
+func fixedTSCFrequency(annotations map[string]string) bool {
+	for _, name := range strings.Split(annotations[converterType], ",") {
+		if ConverterType(name) == FeaturesConverter {
+			return validTSCFrequency(annotations[tscFrequency])
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+// convertFirmware applies the firmware annotations, OpenCore profiles bring their own OVMF files
+func convertFirmware(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	profile, err := getBootProfile(annotations)
+	if err != nil {
+		return err
+	}
+	defaults := hookutil.DefaultFirmware
+	if profile.loaderPath != "" {
+		defaults.Loader = profile.loaderPath
+	}
+	if profile.nvramPath != "" {
+		defaults.NVRamTemplate = profile.nvramPath
+	}
+	return hookutil.ConvertFirmware(annotations, defaults, domainSpec)
+}
+
+// the sidecar's annotations choosing the firmware files, besides the ones of hookutil.ConvertFirmware
+var firmwareAnnotations = []string{
+	loaderPath,
+	nvramPath,
+	loaderDigest,
+	nvramDigest,
+	bootProfileName,
+}
+
+// validateFirmware checks the firmware files with the digests the annotations give
+func validateFirmware(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	digests := hookutil.FirmwareDigests{
+		Loader:        annotations[loaderDigest],
+		NVRamTemplate: annotations[nvramDigest],
+	}
+	return hookutil.ValidateFirmware(annotations, firmwareAnnotations, digests, domainSpec)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil/golden"
+)
+
+// go test -run TestGolden -update rewrites expected.xml of every case.
+// Firmware files, profiles and the SMBIOS Secret are looked up under the case directory.
+const goldenDirectory = "testdata/golden"
+
+func TestGolden(t *testing.T) {
+	golden.Run(t, goldenDirectory, func(dir string, hooksDir string) (hookutil.DomainDefiner, func()) {
+		firmwareRoot, cpuInfo, profiles, smbios := hookutil.FirmwareRoot, cpuInfoPath, profileDirectory, smbiosDirectory
+		hookutil.FirmwareRoot = dir
+		cpuInfoPath = filepath.Join(goldenDirectory, "cpuinfo")
+		profileDirectory = dir
+		smbiosDirectory = dir
+		return new(v1alpha1Server), func() {
+			hookutil.FirmwareRoot, cpuInfoPath, profileDirectory, smbiosDirectory = firmwareRoot, cpuInfo, profiles, smbios
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
+	"github.com/spf13/pflag"
 	"google.golang.org/grpc"
 	"net"
 	"os"
@@ -12,6 +13,7 @@ import (
 
 	v1 "kubevirt.io/client-go/api/v1"
 	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
 	"kubevirt.io/kubevirt/pkg/hooks"
 	hooksInfo "kubevirt.io/kubevirt/pkg/hooks/info"
 	hooksV1alpha1 "kubevirt.io/kubevirt/pkg/hooks/v1alpha1"
@@ -19,9 +21,43 @@ import (
 )
 
 const (
-	hookName         = "osx-hook"
+	hookName           = "osx-hook"
+	publishResultsEnv  = "PUBLISH_RESULTS"
+	metricsAddressEnv  = "METRICS_ADDRESS"
+	smbiosDirectoryEnv = "SMBIOS_DIRECTORY"
 )
 
+// version is set at build time, e.g. go build -ldflags "-X main.version=v0.2.0"
+var version = "dev"
+
+// annotations of these domains are the inputs of the converters
+var annotationDomains = []string{"droidvirt.io/", "osx-kvm.io/"}
+
+type converterFunc func(map[string]string, *domainSchema.DomainSpec) error
+
+// newConverters returns the converters of one domain, queueSizes keeps what the domain schema
+// lacks until the domain is marshalled
+func newConverters(queueSizes hookutil.InterfaceQueueSizes) map[ConverterType]converterFunc {
+	return map[ConverterType]converterFunc{
+		BootLoaderConverter:  addBootLoader,
+		BoardConverter:       convertBoardType,
+		InputDeviceConverter: addInputDevice,
+		VncConverter:         addVncQEMUArgs,
+		NICModelConverter:    convertNicModel,
+		DiskDriverConverter:  convertDiskOptions,
+		DiskBusConverter:     hookutil.ConvertDiskBus,
+		SMBiosConverter:      convertSMBios,
+		NICOptionsConverter: func(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+			return hookutil.ConvertInterfaceOptions(annotations, queueSizes, domainSpec)
+		},
+		USBConverter:      convertUSBControllers,
+		AudioConverter:    hookutil.AddAudioDevice,
+		CPUTuneConverter:  convertCPUTune,
+		FeaturesConverter: convertFeatures,
+		FirmwareConverter: convertFirmware,
+	}
+}
+
 type infoServer struct{}
 
 func (s infoServer) Info(ctx context.Context, params *hooksInfo.InfoParams) (*hooksInfo.InfoResult, error) {
@@ -41,21 +77,22 @@ func (s infoServer) Info(ctx context.Context, params *hooksInfo.InfoParams) (*ho
 	}, nil
 }
 
-type v1alpha1Server struct{}
+type v1alpha1Server struct {
+	// nil unless conversion results are published to the VMI
+	publisher *hookutil.ResultPublisher
+}
 
-func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha1.OnDefineDomainParams) (*hooksV1alpha1.OnDefineDomainResult, error) {
+func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha1.OnDefineDomainParams) (result *hooksV1alpha1.OnDefineDomainResult, err error) {
 	log.Log.Info("Hook's OnDefineDomain callback method has been called")
 
 	vmiJSON := params.GetVmi()
 	vmiSpec := v1.VirtualMachineInstance{}
-	err := json.Unmarshal(vmiJSON, &vmiSpec)
+	err = json.Unmarshal(vmiJSON, &vmiSpec)
 	if err != nil {
 		log.Log.Reason(err).Errorf("Failed to unmarshal given VMI spec: %s", vmiJSON)
 		panic(err)
 	}
 
-	annotations := vmiSpec.GetAnnotations()
-
 	domainXML := params.GetDomainXML()
 	domainSpec := domainSchema.DomainSpec{}
 	err = xml.Unmarshal(domainXML, &domainSpec)
@@ -64,34 +101,57 @@ func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha
 		panic(err)
 	}
 
+	var record *hookutil.ConversionRecord
+	defer func() { s.publisher.Publish(&vmiSpec, record, &domainSpec, err) }()
+
+	annotations := vmiSpec.GetAnnotations()
+	annotations, err = applyVMProfile(annotations)
+	if err != nil {
+		log.Log.Reason(err).Error("Failed to apply VM profile")
+		hookutil.ValidationErrors.WithLabelValues(hookutil.CheckProfile).Inc()
+		return nil, err
+	}
+
 	converterStr, isExist := annotations[converterType]
 	if !isExist {
+		hookutil.ValidationErrors.WithLabelValues(hookutil.CheckConverter).Inc()
 		return nil, fmt.Errorf("miss converter")
 	}
 	log.Log.Infof("enable converter: %s", converterStr)
 
-	converters := strings.Split(converterStr, ",")
-	for _, converter := range converters {
-		switch ConverterType(converter) {
-		case BootLoaderConverter:
-			addBootLoader(annotations, &domainSpec)
-			break
-		case BoardConverter:
-			convertBoardType(&domainSpec)
-			break
-		case InputDeviceConverter:
-			addInputDevice(&domainSpec)
-			break
-		case VncConverter:
-			addVncQEMUArgs(annotations, &domainSpec)
-			break
-		case NICModelConverter:
-			convertNicModel(&domainSpec)
-			break
-		case DiskDriverConverter:
-			convertDiskOptions(annotations, &domainSpec)
-			break
+	record = hookutil.NewConversionRecord(hookName, version, hookutil.InputsHash(annotations, annotationDomains, domainXML))
+	queueSizes := make(hookutil.InterfaceQueueSizes)
+	converters := newConverters(queueSizes)
+	for _, name := range strings.Split(converterStr, ",") {
+		label := name
+		convert, found := converters[ConverterType(name)]
+		if !found {
+			// names come from the VMI, only known ones become metric labels
+			label = hookutil.UnknownConverter
+			convert = func(map[string]string, *domainSchema.DomainSpec) error {
+				return hookutil.Warnf("unknown converter %s", name)
+			}
 		}
+		err = record.Apply(name, &domainSpec, func() error {
+			return hookutil.ObserveConverter(label, func() error {
+				return convert(annotations, &domainSpec)
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateControllers(&domainSpec); err != nil {
+		log.Log.Reason(err).Error("Invalid controllers in updated domain spec")
+		hookutil.ValidationErrors.WithLabelValues(hookutil.CheckControllers).Inc()
+		return nil, err
+	}
+
+	if err := validateFirmware(annotations, &domainSpec); err != nil {
+		log.Log.Reason(err).Error("Invalid firmware in updated domain spec")
+		hookutil.ValidationErrors.WithLabelValues(hookutil.CheckFirmware).Inc()
+		return nil, err
 	}
 
 	newDomainXML, err := xml.Marshal(domainSpec)
@@ -100,6 +160,13 @@ func (s v1alpha1Server) OnDefineDomain(ctx context.Context, params *hooksV1alpha
 		panic(err)
 	}
 
+	newDomainXML = queueSizes.Expand(newDomainXML)
+	newDomainXML, err = record.AddTo(newDomainXML)
+	if err != nil {
+		log.Log.Reason(err).Error("Failed to record conversions in domain metadata")
+		return nil, err
+	}
+
 	log.Log.Info("Successfully updated original domain spec with requested attributes")
 
 	return &hooksV1alpha1.OnDefineDomainResult{
@@ -113,6 +180,32 @@ func main() {
 	// hook) and a callback server (which does the heavy lifting).
 	log.InitializeLogging("osx-hook-sidecar")
 
+	if len(os.Args) > 1 && os.Args[1] == hookutil.RenderCommand {
+		flags := pflag.NewFlagSet(hookutil.RenderCommand, pflag.ExitOnError)
+		os.Exit(hookutil.RunRender(os.Args[2:], flags, func() hookutil.DomainDefiner { return v1alpha1Server{} }))
+	}
+
+	metricsAddress := pflag.String("metrics-address", os.Getenv(metricsAddressEnv), "address to serve prometheus metrics on, e.g. :8443, empty to disable")
+	publishResults := pflag.Bool("publish-results", os.Getenv(publishResultsEnv) == "true", "publish conversion results to the VMI by events and annotations")
+	if dir := os.Getenv(smbiosDirectoryEnv); dir != "" {
+		smbiosDirectory = dir
+	}
+	hookutil.AddQEMUVersionFlag(pflag.CommandLine)
+	pflag.StringVar(&smbiosDirectory, "smbios-directory", smbiosDirectory, "directory the SMBIOS identity Secret is mounted at")
+	pflag.Parse()
+
+	if *metricsAddress != "" {
+		go hookutil.ServeMetrics(hookName, *metricsAddress)
+	}
+
+	var publisher *hookutil.ResultPublisher
+	if *publishResults {
+		var err error
+		if publisher, err = hookutil.NewInClusterResultPublisher(hookName); err != nil {
+			log.Log.Reason(err).Error("Failed to create KubeVirt client, conversion results are not published")
+		}
+	}
+
 	socketPath := hooks.HookSocketsSharedDirectory + "/" + hookName + ".sock"
 	socket, err := net.Listen("unix", socketPath)
 	if err != nil {
@@ -122,9 +215,9 @@ func main() {
 	}
 	defer os.Remove(socketPath)
 
-	server := grpc.NewServer([]grpc.ServerOption{}...)
+	server := grpc.NewServer(grpc.UnaryInterceptor(hookutil.MetricsInterceptor))
 	hooksInfo.RegisterInfoServer(server, infoServer{})
-	hooksV1alpha1.RegisterCallbacksServer(server, v1alpha1Server{})
+	hooksV1alpha1.RegisterCallbacksServer(server, v1alpha1Server{publisher: publisher})
 	log.Log.Infof("Starting hook server exposing 'info' and 'v1alpha1' services on socket %s", socketPath)
 	server.Serve(socket)
 }
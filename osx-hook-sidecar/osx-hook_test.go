@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"encoding/xml"
+	"io/ioutil"
+	"os"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/tools/record"
 	v1 "kubevirt.io/client-go/api/v1"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
 	hooksInfo "kubevirt.io/kubevirt/pkg/hooks/info"
 	hooksV1alpha1 "kubevirt.io/kubevirt/pkg/hooks/v1alpha1"
 	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
@@ -165,3 +172,896 @@ func sortCallbacksPerHookPoint(t *testing.T, callbacksPerHookPoint map[string][]
 		}
 	}
 }
+
+func TestOpenCoreProfile(t *testing.T) {
+	board, err := ioutil.TempFile("", "board")
+	if err != nil {
+		t.Fatalf("Failed to create board definition")
+	}
+	defer os.Remove(board.Name())
+	board.WriteString(`{"osk": "fake-osk"}`)
+
+	domainSpec := domainSchema.DomainSpec{}
+	annotations := map[string]string{
+		bootProfileName: string(OpenCoreVenturaProfile),
+		nvramPath:       fakeNVRamPath,
+		boardPath:       board.Name(),
+		// the profile flags don't depend on the cpuinfo of the test host
+		cpuVendor: intelVendor,
+	}
+
+	if err := addBootLoader(annotations, &domainSpec); err != nil {
+		t.Fatalf("Failed to add boot loader: %v", err)
+	}
+	if err := convertBoardType(annotations, &domainSpec); err != nil {
+		t.Fatalf("Failed to convert board: %v", err)
+	}
+
+	if domainSpec.OS.BootLoader == nil || domainSpec.OS.BootLoader.Path != bootProfiles[OpenCoreVenturaProfile].loaderPath {
+		t.Errorf("Unexpected boot loader")
+	}
+
+	if domainSpec.OS.NVRam == nil || domainSpec.OS.NVRam.NVRam != fakeNVRamPath {
+		t.Errorf("Unexpected nvram")
+	}
+
+	args := domainSpec.QEMUCmd.QEMUArg
+	if len(args) != 6 || args[1].Value != "isa-applesmc,osk=fake-osk" || args[4].Value != "-cpu" ||
+		args[5].Value != "Haswell-noTSX,"+bootProfiles[OpenCoreVenturaProfile].cpuFeatures {
+		t.Errorf("Unexpected board args, %+v", args)
+	}
+
+	// the profile's nvram file is shared by every VM, so it's copied for each
+	root, err := ioutil.TempDir("", "firmware")
+	if err != nil {
+		t.Fatalf("Failed to create firmware root")
+	}
+	defer os.RemoveAll(root)
+	defer func(path string) { hookutil.NVRamDirectory = path }(hookutil.NVRamDirectory)
+	defer func(path string) { hookutil.FirmwareRoot = path }(hookutil.FirmwareRoot)
+	defer func(uid, gid int) { hookutil.QEMUUID, hookutil.QEMUGID = uid, gid }(hookutil.QEMUUID, hookutil.QEMUGID)
+	hookutil.NVRamDirectory = root + "/nvram"
+	hookutil.QEMUUID, hookutil.QEMUGID = os.Getuid(), os.Getgid()
+	hookutil.FirmwareRoot = root
+	template := bootProfiles[OpenCoreVenturaProfile].nvramPath
+	os.MkdirAll(root+"/usr/share/OVMF", 0755)
+	ioutil.WriteFile(root+template, []byte("vars"), 0644)
+
+	delete(annotations, nvramPath)
+	domainSpec = domainSchema.DomainSpec{Name: "default_osx"}
+	if err := addBootLoader(annotations, &domainSpec); err != nil {
+		t.Fatalf("Failed to add boot loader: %v", err)
+	}
+	nvram := domainSpec.OS.NVRam
+	if nvram == nil || nvram.Template != template || nvram.NVRam != hookutil.NVRamDirectory+"/default_osx_VARS.fd" {
+		t.Errorf("Unexpected nvram, %+v", nvram)
+	}
+}
+
+func TestUnknownBootProfile(t *testing.T) {
+	annotations := map[string]string{
+		bootProfileName: "opencore-sonoma",
+	}
+	for name, convert := range map[string]converterFunc{
+		"boot-loader": addBootLoader,
+		"board":       convertBoardType,
+		"firmware":    convertFirmware,
+	} {
+		domainSpec := domainSchema.DomainSpec{}
+		err := convert(annotations, &domainSpec)
+		if _, rejected := err.(hookutil.Warnings); err == nil || rejected {
+			t.Errorf("Unknown boot profile not refused by %s, %v", name, err)
+		}
+		if domainSpec.OS.BootLoader != nil || domainSpec.QEMUCmd != nil {
+			t.Errorf("Domain changed by %s with unknown boot profile, %+v", name, domainSpec)
+		}
+	}
+}
+
+func TestCPUModel(t *testing.T) {
+	cpuInfo, err := ioutil.TempFile("", "cpuinfo")
+	if err != nil {
+		t.Fatalf("Failed to create cpuinfo")
+	}
+	defer os.Remove(cpuInfo.Name())
+	defer func(path string) { cpuInfoPath = path }(cpuInfoPath)
+	cpuInfoPath = cpuInfo.Name()
+
+	if _, err := cpuInfo.WriteString("processor\t: 0\nvendor_id\t: GenuineIntel\n"); err != nil {
+		t.Fatalf("Failed to write cpuinfo: %v", err)
+	}
+	domainSpec := domainSchema.DomainSpec{}
+	annotations := map[string]string{
+		cpuModel:    "Skylake-Client",
+		cpuFeatures: "+invtsc, -pcid,check",
+	}
+	if cpuArg := convertCPUModel(annotations, bootProfiles[CloverProfile], &domainSpec); cpuArg != "" {
+		t.Errorf("Unexpected qemu cpu arg: %s", cpuArg)
+	}
+	if domainSpec.CPU.Mode != "custom" || domainSpec.CPU.Model != "Skylake-Client" || len(domainSpec.CPU.Features) != 2 ||
+		domainSpec.CPU.Features[1].Name != "pcid" || domainSpec.CPU.Features[1].Policy != "disable" {
+		t.Errorf("Unexpected domain cpu, %+v", domainSpec.CPU)
+	}
+
+	if err := cpuInfo.Truncate(0); err != nil {
+		t.Fatalf("Failed to truncate cpuinfo: %v", err)
+	}
+	if _, err := cpuInfo.WriteAt([]byte("processor\t: 0\nvendor_id\t: AuthenticAMD\n"), 0); err != nil {
+		t.Fatalf("Failed to write cpuinfo: %v", err)
+	}
+	domainSpec = domainSchema.DomainSpec{}
+	cpuArg := convertCPUModel(map[string]string{}, bootProfiles[CloverProfile], &domainSpec)
+	if !strings.HasPrefix(cpuArg, "Penryn,") || !strings.Contains(cpuArg, "vendor=GenuineIntel") || strings.Contains(cpuArg, "+pcid") {
+		t.Errorf("Unexpected qemu cpu arg: %s", cpuArg)
+	}
+	if domainSpec.CPU.Model != "" {
+		t.Errorf("Unexpected domain cpu, %+v", domainSpec.CPU)
+	}
+
+	// a fixed tsc frequency drops the probing flag of the profile
+	domainSpec = domainSchema.DomainSpec{}
+	cpuArg = convertCPUModel(map[string]string{converterType: "board,features", tscFrequency: "2600000000"}, bootProfiles[CloverProfile], &domainSpec)
+	if cpuArg == "" || strings.Contains(cpuArg, "vmware-cpuid-freq") {
+		t.Errorf("Unexpected qemu cpu arg: %s", cpuArg)
+	}
+
+	// without the features converter nothing sets the tsc timer, the guest still probes the frequency
+	domainSpec = domainSchema.DomainSpec{}
+	cpuArg = convertCPUModel(map[string]string{converterType: "board", tscFrequency: "2600000000"}, bootProfiles[CloverProfile], &domainSpec)
+	if !strings.Contains(cpuArg, "vmware-cpuid-freq=on") {
+		t.Errorf("Unexpected qemu cpu arg: %s", cpuArg)
+	}
+
+	// the annotation wins over the sidecar's cpuinfo
+	domainSpec = domainSchema.DomainSpec{}
+	cpuArg = convertCPUModel(map[string]string{cpuVendor: intelVendor}, bootProfiles[CloverProfile], &domainSpec)
+	if !strings.HasPrefix(cpuArg, "Penryn,") || !strings.Contains(cpuArg, "+pcid") {
+		t.Errorf("Unexpected qemu cpu arg: %s", cpuArg)
+	}
+}
+
+func TestSMBios(t *testing.T) {
+	secretDir, err := ioutil.TempDir("", "smbios")
+	if err != nil {
+		t.Fatalf("Failed to create secret dir")
+	}
+	defer os.RemoveAll(secretDir)
+	defer func(path string) { smbiosDirectory = path }(smbiosDirectory)
+	smbiosDirectory = secretDir
+	ioutil.WriteFile(secretDir+"/serial", []byte("C02FROMSECRET\n"), 0644)
+	ioutil.WriteFile(secretDir+"/mlb", []byte("C02717306J9JG361M\n"), 0644)
+	ioutil.WriteFile(secretDir+"/uuid", []byte("not-a-uuid\n"), 0644)
+
+	domainSpec := domainSchema.DomainSpec{
+		UUID: "1f9a5bd4-0a5e-4c5a-8f0b-6ee3a1f00001",
+		SysInfo: &domainSchema.SysInfo{
+			Type: "smbios",
+			System: []domainSchema.Entry{
+				{Name: "manufacturer", Value: "KubeVirt"},
+				{Name: "uuid", Value: "1f9a5bd4-0a5e-4c5a-8f0b-6ee3a1f00001"},
+			},
+		},
+	}
+	annotations := map[string]string{
+		smbiosProduct: "iMacPro1,1",
+		smbiosSerial:  "C02TM2ZBHX87",
+	}
+	err = convertSMBios(annotations, &domainSpec)
+	if warnings, ok := err.(hookutil.Warnings); !ok || len(warnings) != 1 || warnings[0] != "Invalid SMBIOS value of "+smbiosUUID+": not-a-uuid" {
+		t.Errorf("Unexpected warnings: %v", err)
+	}
+
+	system := map[string]string{}
+	for _, entry := range domainSpec.SysInfo.System {
+		system[entry.Name] = entry.Value
+	}
+	if system["manufacturer"] != appleManufacturer || system["product"] != "iMacPro1,1" || system["serial"] != "C02TM2ZBHX87" {
+		t.Errorf("Unexpected system sysinfo, %+v", domainSpec.SysInfo.System)
+	}
+
+	if system["uuid"] != domainSpec.UUID || domainSpec.UUID != "1f9a5bd4-0a5e-4c5a-8f0b-6ee3a1f00001" || domainSpec.QEMUCmd != nil {
+		t.Errorf("Invalid uuid applied, %s, %+v", domainSpec.UUID, domainSpec.QEMUCmd)
+	}
+
+	if len(domainSpec.SysInfo.BaseBoard) != 2 || domainSpec.SysInfo.BaseBoard[1].Value != "C02717306J9JG361M" {
+		t.Errorf("Unexpected baseboard sysinfo, %+v", domainSpec.SysInfo.BaseBoard)
+	}
+
+	if domainSpec.OS.SMBios == nil || domainSpec.OS.SMBios.Mode != "sysinfo" {
+		t.Errorf("SMBIOS mode not set")
+	}
+
+	// the system uuid is set by qemu args, the domain keeps the uuid KubeVirt gave it
+	annotations[smbiosUUID] = "007076A6-F2A2-4461-BBE5-BAD019F8025A"
+	if err := convertSMBios(annotations, &domainSpec); err != nil {
+		t.Errorf("Failed to convert SMBIOS: %v", err)
+	}
+	args := domainSpec.QEMUCmd.QEMUArg
+	if domainSpec.UUID != "1f9a5bd4-0a5e-4c5a-8f0b-6ee3a1f00001" || system["uuid"] != domainSpec.UUID ||
+		len(args) != 2 || args[0].Value != "-smbios" || args[1].Value != "type=1,uuid=007076A6-F2A2-4461-BBE5-BAD019F8025A" {
+		t.Errorf("Unexpected uuid, %s, %+v", domainSpec.UUID, args)
+	}
+}
+
+func TestBoardDefinition(t *testing.T) {
+	board, err := ioutil.TempFile("", "board")
+	if err != nil {
+		t.Fatalf("Failed to create board definition")
+	}
+	defer os.Remove(board.Name())
+	board.WriteString(`{"osk": "fake-osk", "args": ["-device", "isa-applesmc,osk=$OSK", "-smbios", "type=2", "-device", "usb-ehci,id=ehci"]}`)
+
+	domainSpec := domainSchema.DomainSpec{}
+	annotations := map[string]string{
+		boardPath:   board.Name(),
+		cpuFeatures: "+invtsc",
+	}
+	if err := convertBoardType(annotations, &domainSpec); err != nil {
+		t.Fatalf("Failed to convert board: %v", err)
+	}
+
+	args := domainSpec.QEMUCmd.QEMUArg
+	if len(args) < 6 || args[1].Value != "isa-applesmc,osk=fake-osk" || args[5].Value != "usb-ehci,id=ehci" {
+		t.Errorf("Unexpected board args, %+v", args)
+	}
+
+	domainSpec = domainSchema.DomainSpec{}
+	annotations[boardPath] = board.Name() + ".missing"
+	err = convertBoardType(annotations, &domainSpec)
+	if _, warning := err.(hookutil.Warnings); err == nil || warning {
+		t.Errorf("Domain not refused without board definition, %v", err)
+	}
+}
+
+func TestNicModel(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Interfaces: []domainSchema.Interface{
+				{
+					Type:   "ethernet",
+					Model:  &domainSchema.Model{Type: "virtio"},
+					Driver: &domainSchema.InterfaceDriver{Name: "vhost"},
+					Alias:  &domainSchema.Alias{Name: "default"},
+				},
+				{
+					Type:  "ethernet",
+					Alias: &domainSchema.Alias{Name: "net1"},
+				},
+			},
+		},
+	}
+
+	convertNicModel(map[string]string{}, &domainSpec)
+	for _, nic := range domainSpec.Devices.Interfaces {
+		if nic.Model == nil || nic.Model.Type != defaultNicModel || nic.Driver != nil {
+			t.Errorf("NIC model not change, %+v", nic)
+		}
+	}
+
+	annotations := map[string]string{
+		nicModel: "net1:e1000e,default:foo",
+	}
+	convertNicModel(annotations, &domainSpec)
+	if domainSpec.Devices.Interfaces[0].Model.Type != defaultNicModel || domainSpec.Devices.Interfaces[1].Model.Type != "e1000e" {
+		t.Errorf("Unexpected NIC models, %+v, %+v", domainSpec.Devices.Interfaces[0].Model, domainSpec.Devices.Interfaces[1].Model)
+	}
+}
+
+func TestInputDevice(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Inputs: []domainSchema.Input{
+				{
+					Type:  "tablet",
+					Bus:   "usb",
+					Alias: &domainSchema.Alias{Name: "tablet0"},
+				},
+			},
+			Controllers: []domainSchema.Controller{
+				{
+					Type:  "usb",
+					Index: "0",
+					Model: "none",
+				},
+			},
+		},
+	}
+	annotations := map[string]string{
+		inputDevices:    "tablet:usb,keyboard:virtio,tablet:ps2,multitouch:virtio",
+		inputController: "qemu-xhci",
+	}
+	err := addInputDevice(annotations, &domainSpec)
+	if warnings, ok := err.(hookutil.Warnings); !ok || len(warnings) != 2 ||
+		warnings[1] != "Multitouch input needs QEMU 8.0 or later, set by --qemu-version: multitouch:virtio" {
+		t.Errorf("Unexpected warnings: %v", err)
+	}
+
+	inputs := domainSpec.Devices.Inputs
+	if len(inputs) != 2 || inputs[0].Alias == nil || inputs[1].Type != "keyboard" || inputs[1].Bus != "virtio" {
+		t.Errorf("Unexpected inputs, %+v", inputs)
+	}
+	if domainSpec.QEMUCmd != nil {
+		t.Errorf("Multitouch device added without qemu version, %+v", domainSpec.QEMUCmd)
+	}
+
+	controllers := domainSpec.Devices.Controllers
+	if len(controllers) != 1 || controllers[0].Model != "qemu-xhci" {
+		t.Errorf("Unexpected controllers, %+v", controllers)
+	}
+
+	defer func(version string) { hookutil.QEMUVersion = version }(hookutil.QEMUVersion)
+	hookutil.QEMUVersion = "8.2.0"
+	annotations[inputDevices] = "multitouch:virtio"
+	if err := addInputDevice(annotations, &domainSpec); err != nil {
+		t.Errorf("Failed to add multitouch device: %v", err)
+	}
+	if domainSpec.QEMUCmd == nil || len(domainSpec.QEMUCmd.QEMUArg) != 2 || domainSpec.QEMUCmd.QEMUArg[1].Value != "virtio-multitouch-pci" {
+		t.Errorf("Multitouch device not added, %+v", domainSpec.QEMUCmd)
+	}
+}
+
+func TestUSBControllers(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Controllers: []domainSchema.Controller{
+				{
+					Type:  "usb",
+					Index: "0",
+					Model: "piix3-uhci",
+				},
+				{
+					Type:  "usb",
+					Index: "0",
+					Model: "none",
+				},
+				{
+					Type:  "scsi",
+					Index: "0",
+					Model: "virtio-scsi",
+				},
+			},
+		},
+	}
+	domainSpecXML, err := xml.Marshal(domainSpec)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(map[string]string{
+		converterType:  string(USBConverter),
+		usbControllers: "10:piix3-uhci,1:piix3-uhci,0:qemu-xhci,2:qemu-xhci",
+		usbPorts:       "8",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	server := new(v1alpha1Server)
+	result, err := server.OnDefineDomain(context.TODO(), &params)
+	if err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+
+	updateDomainSpec := domainSchema.DomainSpec{}
+	err = xml.Unmarshal(result.GetDomainXML(), &updateDomainSpec)
+	if err != nil {
+		t.Errorf("Failed to unmarshal the domain spec")
+	}
+
+	controllers := updateDomainSpec.Devices.Controllers
+	if len(controllers) != 5 || controllers[0].Model != "qemu-xhci" || controllers[2].Index != "1" || controllers[2].Model != "piix3-uhci" ||
+		controllers[3].Index != "2" || controllers[4].Index != "10" {
+		t.Errorf("Unexpected controllers, %+v", controllers)
+	}
+
+	if err := validateControllers(&domainSpec); err == nil {
+		t.Errorf("Duplicated controllers not detected")
+	}
+}
+
+func TestCPUTune(t *testing.T) {
+	cpuSet, err := ioutil.TempFile("", "cpuset")
+	if err != nil {
+		t.Fatalf("Failed to create cpuset")
+	}
+	defer os.Remove(cpuSet.Name())
+	cpuSet.WriteString("2-4,8\n")
+
+	domainSpec := domainSchema.DomainSpec{
+		VCPU: &domainSchema.VCPU{
+			Placement: "static",
+			CPUs:      2,
+		},
+	}
+	annotations := map[string]string{
+		vcpuPin:     "auto",
+		cpuSetPath:  cpuSet.Name(),
+		ioThreadPin: "1:8;x:9",
+		numaMemory:  "strict:0",
+	}
+	convertCPUTune(annotations, &domainSpec)
+
+	cpuTune := domainSpec.CPUTune
+	if cpuTune == nil || len(cpuTune.VCPUPin) != 2 || cpuTune.VCPUPin[1].CPUSet != "3" ||
+		cpuTune.EmulatorPin == nil || cpuTune.EmulatorPin.CPUSet != "4,8" ||
+		len(cpuTune.IOThreadPin) != 1 || cpuTune.IOThreadPin[0].CPUSet != "8" {
+		t.Errorf("Unexpected cputune, %+v", cpuTune)
+	}
+
+	if domainSpec.NUMATune == nil || domainSpec.NUMATune.Memory.Mode != "strict" || domainSpec.NUMATune.Memory.NodeSet != "0" {
+		t.Errorf("Unexpected numatune, %+v", domainSpec.NUMATune)
+	}
+
+	domainSpec = domainSchema.DomainSpec{}
+	convertCPUTune(map[string]string{vcpuPin: "1:5-6;0:4"}, &domainSpec)
+	if domainSpec.CPUTune == nil || len(domainSpec.CPUTune.VCPUPin) != 2 || domainSpec.CPUTune.VCPUPin[1].CPUSet != "5-6" {
+		t.Errorf("Unexpected cputune, %+v", domainSpec.CPUTune)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	domainSpec := domainSchema.DomainSpec{
+		Clock: &domainSchema.Clock{
+			Offset: "utc",
+			Timer:  []domainSchema.Timer{{Name: "hpet", Present: "yes"}},
+		},
+	}
+	annotations := map[string]string{
+		hypervFeatures: "relaxed, spinlocks,-evmcs,unknown",
+		kvmHidden:      "true",
+		vmport:         "false",
+		hpetTimer:      "false",
+		tscFrequency:   "2600000000",
+	}
+	convertFeatures(annotations, &domainSpec)
+
+	hyperv := domainSpec.Features.Hyperv
+	if hyperv == nil || hyperv.Relaxed == nil || hyperv.Relaxed.State != "on" ||
+		hyperv.Spinlocks == nil || *hyperv.Spinlocks.Retries != defaultSpinlockRetries ||
+		hyperv.EVMCS == nil || hyperv.EVMCS.State != "off" {
+		t.Errorf("Unexpected hyperv features, %+v", hyperv)
+	}
+	if domainSpec.Features.KVM == nil || domainSpec.Features.KVM.Hidden.State != "on" {
+		t.Errorf("Unexpected kvm features, %+v", domainSpec.Features.KVM)
+	}
+
+	timers := domainSpec.Clock.Timer
+	if len(timers) != 2 || timers[0].Present != "no" || timers[1].Name != "tsc" || timers[1].Frequency != "2600000000" {
+		t.Errorf("Unexpected timers, %+v", timers)
+	}
+
+	args := domainSpec.QEMUCmd.QEMUArg
+	if len(args) != 2 || args[1].Value != "vmport=off" {
+		t.Errorf("Unexpected qemu args, %+v", args)
+	}
+
+	domainSpec = domainSchema.DomainSpec{}
+	convertFeatures(map[string]string{clockOffset: "localtime"}, &domainSpec)
+	if domainSpec.Features != nil {
+		t.Errorf("Unexpected features, %+v", domainSpec.Features)
+	}
+}
+
+func TestFirmware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nvram")
+	if err != nil {
+		t.Fatalf("Failed to create nvram dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { hookutil.NVRamDirectory = path }(hookutil.NVRamDirectory)
+	defer func(uid, gid int) { hookutil.QEMUUID, hookutil.QEMUGID = uid, gid }(hookutil.QEMUUID, hookutil.QEMUGID)
+	hookutil.NVRamDirectory = dir + "/nvram"
+	hookutil.QEMUUID, hookutil.QEMUGID = os.Getuid(), os.Getgid()
+
+	template := dir + "/OVMF_VARS-1920x1080.fd"
+	if err := ioutil.WriteFile(template, []byte("vars"), 0644); err != nil {
+		t.Fatalf("Failed to create nvram template")
+	}
+
+	domainSpec := domainSchema.DomainSpec{
+		Name: "default_osx",
+		OS: domainSchema.OS{
+			Type: domainSchema.OSType{OS: "hvm", Machine: "q35"},
+		},
+	}
+	annotations := map[string]string{
+		bootProfileName:                  string(OpenCoreProfile),
+		hookutil.FirmwareAnnotation:      "uefi",
+		hookutil.NVRamTemplateAnnotation: template,
+	}
+	convertFirmware(annotations, &domainSpec)
+
+	loader := domainSpec.OS.BootLoader
+	if loader == nil || loader.Path != bootProfiles[OpenCoreProfile].loaderPath || loader.Secure != "no" {
+		t.Errorf("Unexpected loader, %+v", loader)
+	}
+	nvram := domainSpec.OS.NVRam
+	if nvram == nil || nvram.Template != template || nvram.NVRam != hookutil.NVRamDirectory+"/default_osx_VARS.fd" {
+		t.Errorf("Unexpected nvram, %+v", nvram)
+	}
+}
+
+func TestNVRamStorage(t *testing.T) {
+	storage, err := ioutil.TempDir("", "nvram-pvc")
+	if err != nil {
+		t.Fatalf("Failed to create nvram storage")
+	}
+	defer os.RemoveAll(storage)
+
+	template := storage + "/OVMF_VARS.fd"
+	if err := ioutil.WriteFile(template, []byte("vars"), 0644); err != nil {
+		t.Fatalf("Failed to create nvram template")
+	}
+
+	annotations := map[string]string{
+		loaderPath:                      fakeLoaderPath,
+		nvramPath:                       template,
+		hookutil.NVRamStorageAnnotation: storage,
+	}
+	domainSpec := domainSchema.DomainSpec{Name: "default_osx"}
+	addBootLoader(annotations, &domainSpec)
+
+	nvram := domainSpec.OS.NVRam
+	if nvram == nil || nvram.Template != template || nvram.NVRam != storage+"/default_osx_VARS.fd" {
+		t.Fatalf("Unexpected nvram, %+v", nvram)
+	}
+
+	// a restarted pod finds the variables the guest wrote
+	ioutil.WriteFile(nvram.NVRam, []byte("written"), 0666)
+	domainSpec = domainSchema.DomainSpec{Name: "default_osx"}
+	addBootLoader(annotations, &domainSpec)
+	if content, _ := ioutil.ReadFile(domainSpec.OS.NVRam.NVRam); string(content) != "written" {
+		t.Errorf("NVRam not kept on storage")
+	}
+
+	// an unmounted volume refuses the domain rather than share the template or lose the copy with the pod
+	annotations[hookutil.NVRamStorageAnnotation] = storage + "/missing"
+	domainSpec = domainSchema.DomainSpec{Name: "default_osx"}
+	if err := addBootLoader(annotations, &domainSpec); err == nil {
+		t.Errorf("Domain not refused without nvram storage, %+v", domainSpec.OS.NVRam)
+	}
+}
+
+func TestValidateFirmware(t *testing.T) {
+	root, err := ioutil.TempDir("", "firmware")
+	if err != nil {
+		t.Fatalf("Failed to create firmware root")
+	}
+	defer os.RemoveAll(root)
+	defer func(path string) { hookutil.FirmwareRoot = path }(hookutil.FirmwareRoot)
+	hookutil.FirmwareRoot = root
+
+	os.MkdirAll(root+"/usr/share/OVMF", 0755)
+	ioutil.WriteFile(root+"/usr/share/OVMF/OVMF_CODE.fd", []byte("code"), 0644)
+
+	domainSpecXML, err := xml.Marshal(domainSchema.DomainSpec{})
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(map[string]string{
+		converterType: string(BootLoaderConverter),
+		loaderPath:    "/usr/share/OVMF/OVMF_CODE.fd",
+		nvramPath:     "/usr/share/OVMF/OVMF_VARS.fd",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	// the nvram file given verbatim is written by the guest, libvirt creates it when missing
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	server := new(v1alpha1Server)
+	if _, err := server.OnDefineDomain(context.TODO(), &params); err != nil {
+		t.Errorf("Unexpected error, %v", err)
+	}
+
+	// sha256 of "code"
+	digest := "5694d08a2e53ffcae0c3103e5ad6f6076abd960eb1f8a56577040bc1028f702b"
+	annotations := map[string]string{loaderDigest: "sha256:" + strings.ToUpper(digest)}
+	domainSpec := domainSchema.DomainSpec{}
+	domainSpec.OS.BootLoader = &domainSchema.Loader{Path: "/usr/share/OVMF/OVMF_CODE.fd"}
+	if err := validateFirmware(annotations, &domainSpec); err != nil {
+		t.Errorf("Unexpected error, %v", err)
+	}
+
+	annotations[loaderDigest] = "0000"
+	if err := validateFirmware(annotations, &domainSpec); err == nil || !strings.Contains(err.Error(), "expected 0000") {
+		t.Errorf("Digest mismatch not detected, %v", err)
+	}
+
+	annotations = map[string]string{nvramDigest: digest}
+	domainSpec.OS.NVRam = &domainSchema.NVRam{Template: "/usr/share/OVMF/OVMF_CODE.fd", NVRam: "/missing/default_osx_VARS.fd"}
+	if err := validateFirmware(annotations, &domainSpec); err != nil {
+		t.Errorf("Unexpected error, %v", err)
+	}
+	domainSpec.OS.NVRam.Template = "/usr/share/OVMF/OVMF_VARS.fd"
+	if err := validateFirmware(annotations, &domainSpec); err == nil || !strings.Contains(err.Error(), "invalid nvram template") {
+		t.Errorf("Missing nvram template not detected, %v", err)
+	}
+
+	// firmware KubeVirt chose isn't checked without firmware annotations
+	if err := validateFirmware(map[string]string{}, &domainSpec); err != nil {
+		t.Errorf("Unexpected error, %v", err)
+	}
+}
+
+func TestVMProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatalf("Failed to create profile dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { profileDirectory = path }(profileDirectory)
+	profileDirectory = dir
+
+	profile := `{
+		"converter.droidvirt.io/type": "nic-model,nic-options",
+		"nic.droidvirt.io/models": "e1000e",
+		"nic.droidvirt.io/mtu": "default:1400"
+	}`
+	if err := ioutil.WriteFile(dir+"/macos-ventura.json", []byte(profile), 0644); err != nil {
+		t.Fatalf("Failed to write profile")
+	}
+
+	domainSpec := domainSchema.DomainSpec{
+		Devices: domainSchema.Devices{
+			Interfaces: []domainSchema.Interface{
+				{
+					Type:  "ethernet",
+					Alias: &domainSchema.Alias{Name: "default"},
+				},
+				{
+					Type:  "ethernet",
+					Alias: &domainSchema.Alias{Name: "net1"},
+				},
+			},
+		},
+	}
+	domainSpecXML, err := xml.Marshal(domainSpec)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(map[string]string{
+		profileName: "macos-ventura",
+		nicModel:    "net1:virtio",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	server := new(v1alpha1Server)
+	result, err := server.OnDefineDomain(context.TODO(), &params)
+	if err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+
+	updateDomainSpec := domainSchema.DomainSpec{}
+	if err := xml.Unmarshal(result.GetDomainXML(), &updateDomainSpec); err != nil {
+		t.Errorf("Failed to unmarshal the domain spec")
+	}
+
+	// the VMI annotation replaces the one from profile as a whole
+	interfaces := updateDomainSpec.Devices.Interfaces
+	if interfaces[0].Model != nil || interfaces[1].Model == nil || interfaces[1].Model.Type != "virtio" {
+		t.Errorf("Unexpected NIC models, %+v, %+v", interfaces[0].Model, interfaces[1].Model)
+	}
+	if interfaces[0].MTU == nil || interfaces[0].MTU.Size != "1400" {
+		t.Errorf("Unexpected NIC MTU, %+v", interfaces[0].MTU)
+	}
+
+	for _, name := range []string{"missing", "../macos-ventura"} {
+		if _, err := applyVMProfile(map[string]string{profileName: name}); err == nil {
+			t.Errorf("Invalid profile %s not refused", name)
+		}
+	}
+}
+
+func TestRenderDomain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatalf("Failed to create render dir")
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { hookutil.NVRamDirectory = path }(hookutil.NVRamDirectory)
+	defer func(dryRun bool) { hookutil.DryRun = dryRun }(hookutil.DryRun)
+	hookutil.NVRamDirectory = dir + "/nvram"
+	hookutil.DryRun = true
+
+	domainXML := `<domain type="kvm"><name>default_osx</name></domain>`
+	vmiYAML := `apiVersion: kubevirt.io/v1alpha3
+kind: VirtualMachineInstance
+metadata:
+  name: osx
+  annotations:
+    converter.droidvirt.io/type: boot-loader
+    profile.osx-kvm.io/bootloader: opencore
+`
+	ioutil.WriteFile(dir+"/domain.xml", []byte(domainXML), 0644)
+	ioutil.WriteFile(dir+"/vmi.yaml", []byte(vmiYAML), 0644)
+
+	// neither the OVMF files nor the nvram directory exist here
+	out := &bytes.Buffer{}
+	if err := hookutil.RenderDomain(v1alpha1Server{}, dir+"/domain.xml", dir+"/vmi.yaml", true, out); err != nil {
+		t.Fatalf("Failed to render domain: %v", err)
+	}
+	if diff := out.String(); !strings.Contains(diff, "\n+    <nvram template=\"/usr/share/OVMF/OVMF_VARS-1920x1080.fd\">"+dir+"/nvram/default_osx_VARS.fd</nvram>\n") {
+		t.Errorf("Unexpected diff, %s", diff)
+	}
+	if _, err := os.Stat(hookutil.NVRamDirectory); !os.IsNotExist(err) {
+		t.Errorf("NVRAM copied by render, %v", err)
+	}
+}
+
+func TestConversionRecord(t *testing.T) {
+	domainSpecXML, err := xml.Marshal(domainSchema.DomainSpec{})
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(map[string]string{
+		converterType: "features,audio,foo",
+		kvmHidden:     "true",
+		hpetTimer:     "maybe",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	server := new(v1alpha1Server)
+	result, err := server.OnDefineDomain(context.TODO(), &params)
+	if err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+
+	metadata := struct {
+		Record hookutil.ConversionRecord `xml:"metadata>droidvirt"`
+	}{}
+	if err := xml.Unmarshal(result.GetDomainXML(), &metadata); err != nil {
+		t.Fatalf("Failed to unmarshal the domain metadata: %v", err)
+	}
+	record := metadata.Record
+	// audio changed nothing without a model
+	if record.Sidecar != hookName || len(record.Converters) != 1 || record.Converters[0] != string(FeaturesConverter) ||
+		len(record.Warnings) != 2 || record.Warnings[0] != "Invalid hpet timer: maybe" || record.Warnings[1] != "unknown converter foo" ||
+		!strings.HasPrefix(record.InputsHash, "sha256:") {
+		t.Errorf("Unexpected conversion record, %+v", record)
+	}
+}
+
+type fakeVMIClient struct {
+	patches chan string
+}
+
+func (c fakeVMIClient) PatchVMI(namespace string, name string, patch []byte) error {
+	c.patches <- namespace + "/" + name + " " + string(patch)
+	return nil
+}
+
+func TestPublishResults(t *testing.T) {
+	client := fakeVMIClient{patches: make(chan string, 1)}
+	recorder := record.NewFakeRecorder(10)
+	server := v1alpha1Server{publisher: hookutil.NewResultPublisher(client, recorder)}
+	// published in the background
+	expectEvent := func(expected string) {
+		select {
+		case event := <-recorder.Events:
+			if event != expected {
+				t.Errorf("Unexpected event %s, expected %s", event, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Event %s not published", expected)
+		}
+	}
+
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.Name = "osx"
+	vmi.Namespace = "default"
+	vmi.SetAnnotations(map[string]string{
+		converterType:    "vnc,foo",
+		vncPort:          "5901",
+		vncWebsocketPort: "5911",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	params := hooksV1alpha1.OnDefineDomainParams{[]byte(`<domain type="kvm"><name>default_osx</name></domain>`), vmiJSON}
+	if _, err := server.OnDefineDomain(context.TODO(), &params); err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+	expectEvent("Normal Converted Applied converters: vnc")
+	expectEvent("Warning ConversionWarning unknown converter foo")
+	select {
+	case patch := <-client.patches:
+		expected := `default/osx {"metadata":{"annotations":{"status.droidvirt.io/graphics":"{\"vnc\":5901,\"websocket\":5911}"}}}`
+		if patch != expected {
+			t.Errorf("Unexpected VMI patch, %s", patch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No VMI patch published")
+	}
+
+	// a refused domain is reported too
+	vmi.SetAnnotations(map[string]string{
+		converterType: "board",
+		boardPath:     "/missing/board.yaml",
+	})
+	if vmiJSON, err = json.Marshal(vmi); err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	params = hooksV1alpha1.OnDefineDomainParams{[]byte(`<domain type="kvm"><name>default_osx</name></domain>`), vmiJSON}
+	if _, err := server.OnDefineDomain(context.TODO(), &params); err == nil {
+		t.Fatalf("Domain without board definition not refused")
+	}
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning ConversionFailed board: ") {
+			t.Errorf("Unexpected event, %s", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Refusal not published")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	successes := testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues(string(FeaturesConverter), hookutil.ResultSuccess))
+	rejected := testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues(string(AudioConverter), hookutil.ResultFailure))
+	unknown := testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues(hookutil.UnknownConverter, hookutil.ResultFailure))
+	annotationErrors := testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckAnnotation))
+	converterErrors := testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckConverter))
+
+	domainSpecXML, err := xml.Marshal(domainSchema.DomainSpec{})
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	vmi := new(v1.VirtualMachineInstance)
+	vmi.SetAnnotations(map[string]string{
+		converterType:                 "features,audio,foo",
+		kvmHidden:                     "true",
+		hookutil.AudioModelAnnotation: "sb16",
+	})
+	vmiJSON, err := json.Marshal(vmi)
+	if err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	params := hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	server := new(v1alpha1Server)
+	if _, err := server.OnDefineDomain(context.TODO(), &params); err != nil {
+		t.Fatalf("Failed to invoke OnDefineDomain: %v", err)
+	}
+
+	if testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues(string(FeaturesConverter), hookutil.ResultSuccess)) != successes+1 ||
+		testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues(string(AudioConverter), hookutil.ResultFailure)) != rejected+1 ||
+		testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues(hookutil.UnknownConverter, hookutil.ResultFailure)) != unknown+1 {
+		t.Errorf("Converter runs not counted")
+	}
+	if testutil.ToFloat64(hookutil.ConverterRuns.WithLabelValues("foo", hookutil.ResultFailure)) != 0 {
+		t.Errorf("Unknown converter name used as label")
+	}
+	// the rejected audio model and the unknown converter
+	if testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckAnnotation)) != annotationErrors+2 {
+		t.Errorf("Rejected annotations not counted")
+	}
+
+	vmi.SetAnnotations(map[string]string{kvmHidden: "true"})
+	if vmiJSON, err = json.Marshal(vmi); err != nil {
+		t.Errorf("Failed to marshal JSON")
+	}
+	params = hooksV1alpha1.OnDefineDomainParams{domainSpecXML, vmiJSON}
+	if _, err := server.OnDefineDomain(context.TODO(), &params); err == nil {
+		t.Fatalf("Domain not refused without converters")
+	}
+	if testutil.ToFloat64(hookutil.ValidationErrors.WithLabelValues(hookutil.CheckConverter)) != converterErrors+1 {
+		t.Errorf("Missing converter annotation not counted")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+)
+
+type BootProfile string
+
+const (
+	CloverProfile          BootProfile = "clover"
+	OpenCoreProfile        BootProfile = "opencore"
+	OpenCoreVenturaProfile BootProfile = "opencore-ventura"
+)
+
+// bootProfile is the board and firmware setup a macOS bootloader expects
+type bootProfile struct {
+	// default OVMF files, loader and nvram annotations take precedence
+	loaderPath string
+	nvramPath  string
+	smbiosType string
+	// qemu -cpu model and its comma separated flags
+	cpuModel    string
+	cpuFeatures string
+}
+
+var bootProfiles = map[BootProfile]bootProfile{
+	// Clover with Mojave and older, OVMF files are given by annotations
+	CloverProfile: {
+		smbiosType:  "type=2",
+		cpuModel:    "Penryn",
+		cpuFeatures: "kvm=on,vendor=GenuineIntel,+invtsc,vmware-cpuid-freq=on,+pcid,+ssse3,+sse4.2,+popcnt,+avx,+aes,+xsave,+xsaveopt,check",
+	},
+	// OpenCore with Big Sur and Monterey
+	OpenCoreProfile: {
+		loaderPath:  "/usr/share/OVMF/OVMF_CODE.fd",
+		nvramPath:   "/usr/share/OVMF/OVMF_VARS-1920x1080.fd",
+		smbiosType:  "type=2",
+		cpuModel:    "Penryn",
+		cpuFeatures: "kvm=on,vendor=GenuineIntel,+invtsc,vmware-cpuid-freq=on,+ssse3,+sse4.2,+popcnt,+avx,+aes,+xsave,+xsaveopt,check",
+	},
+	// OpenCore with Ventura, which requires AVX2
+	OpenCoreVenturaProfile: {
+		loaderPath:  "/usr/share/OVMF/OVMF_CODE.fd",
+		nvramPath:   "/usr/share/OVMF/OVMF_VARS-1920x1080.fd",
+		smbiosType:  "type=2",
+		cpuModel:    "Haswell-noTSX",
+		cpuFeatures: "kvm=on,vendor=GenuineIntel,+invtsc,vmware-cpuid-freq=on,+ssse3,+sse4.2,+popcnt,+avx,+avx2,+aes,+xsave,+xsaveopt,check",
+	},
+}
+
+// getBootProfile refuses an unknown profile name, Clover firmware and board don't boot an OpenCore disk
+func getBootProfile(annotations map[string]string) (bootProfile, error) {
+	name, found := annotations[bootProfileName]
+	if !found {
+		return bootProfiles[CloverProfile], nil
+	}
+	profile, found := bootProfiles[BootProfile(name)]
+	if !found {
+		return bootProfile{}, fmt.Errorf("unknown boot profile: %s", name)
+	}
+	return profile, nil
+}
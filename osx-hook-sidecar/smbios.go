@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+const appleManufacturer = "Apple Inc."
+
+// SMBIOS identity Secret mounted into the sidecar by the injector, it's skipped when missing.
+// Secret keys are the annotation names without the domain, e.g. "serial"
+var smbiosDirectory = "/etc/osx-hook-sidecar/smbios"
+
+var smbiosAnnotations = []string{
+	smbiosProduct,
+	smbiosSerial,
+	smbiosMLB,
+	smbiosUUID,
+	smbiosROM,
+}
+
+var smbiosFormats = map[string]*regexp.Regexp{
+	smbiosUUID: regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`),
+	smbiosROM:  regexp.MustCompile(`^[0-9a-fA-F]{12}$`),
+}
+
+func loadSMBiosIdentity(annotations map[string]string, warnings *hookutil.Warnings) map[string]string {
+	identity := make(map[string]string)
+
+	for _, name := range smbiosAnnotations {
+		key := name[strings.Index(name, "/")+1:]
+		data, err := ioutil.ReadFile(filepath.Join(smbiosDirectory, key))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				warnings.Addf("Failed to read SMBIOS %s from %s: %v", key, smbiosDirectory, err)
+			}
+			continue
+		}
+		identity[name] = strings.TrimSpace(string(data))
+	}
+
+	// annotations take precedence over the Secret
+	for _, name := range smbiosAnnotations {
+		if value, found := annotations[name]; found {
+			identity[name] = value
+		}
+	}
+
+	for name, value := range identity {
+		if format, found := smbiosFormats[name]; found && !format.MatchString(value) {
+			warnings.Addf("Invalid SMBIOS value of %s: %s", name, value)
+			delete(identity, name)
+		}
+	}
+	return identity
+}
+
+func convertSMBios(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	var warnings hookutil.Warnings
+	identity := loadSMBiosIdentity(annotations, &warnings)
+	if len(identity) == 0 {
+		log.Log.Info("No SMBIOS identity given")
+		return warnings.Err()
+	}
+
+	if domainSpec.SysInfo == nil {
+		domainSpec.SysInfo = &domainSchema.SysInfo{
+			Type: "smbios",
+		}
+	}
+	sysInfo := domainSpec.SysInfo
+
+	sysInfo.System = setSysInfoEntry(sysInfo.System, "manufacturer", appleManufacturer)
+	if product, found := identity[smbiosProduct]; found {
+		sysInfo.System = setSysInfoEntry(sysInfo.System, "product", product)
+		sysInfo.System = setSysInfoEntry(sysInfo.System, "family", strings.TrimRight(product, "0123456789,"))
+	}
+	if serial, found := identity[smbiosSerial]; found {
+		sysInfo.System = setSysInfoEntry(sysInfo.System, "serial", serial)
+	}
+	if uuid, found := identity[smbiosUUID]; found {
+		// libvirt refuses a sysinfo uuid different from the domain one, which stays KubeVirt's,
+		// qemu takes the last type=1 uuid and the qemu args come after the ones libvirt renders
+		hookutil.AppendQEMUArgs(domainSpec, "-smbios", "type=1,uuid="+uuid)
+	}
+	if mlb, found := identity[smbiosMLB]; found {
+		sysInfo.BaseBoard = setSysInfoEntry(sysInfo.BaseBoard, "manufacturer", appleManufacturer)
+		sysInfo.BaseBoard = setSysInfoEntry(sysInfo.BaseBoard, "serial", mlb)
+	}
+	if rom, found := identity[smbiosROM]; found {
+		// sysinfo has no ROM entry, expose it as an OEM string for the bootloader config
+		hookutil.AppendQEMUArgs(domainSpec, "-smbios", "type=11,value=ROM:"+strings.ToUpper(rom))
+	}
+
+	domainSpec.OS.SMBios = &domainSchema.SMBios{
+		Mode: "sysinfo",
+	}
+	return warnings.Err()
+}
+
+func setSysInfoEntry(entries []domainSchema.Entry, name string, value string) []domainSchema.Entry {
+	for idx, entry := range entries {
+		if entry.Name == name {
+			entries[idx].Value = value
+			return entries
+		}
+	}
+	return append(entries, domainSchema.Entry{
+		Name:  name,
+		Value: value,
+	})
+}
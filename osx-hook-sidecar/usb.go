@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"kubevirt.io/client-go/log"
+	"kubevirt.io/kubevirt/cmd/droidvirt-sidecar/hookutil"
+	domainSchema "kubevirt.io/kubevirt/pkg/virt-launcher/virtwrap/api"
+)
+
+// qemu-xhci has at most 15 USB2 and 15 USB3 ports
+const maxXHCIPorts = 15
+
+var usbControllerModels = map[string]bool{
+	"qemu-xhci":  true,
+	"nec-xhci":   true,
+	"piix3-uhci": true,
+	"ich9-ehci1": true,
+}
+
+func convertUSBControllers(annotations map[string]string, domainSpec *domainSchema.DomainSpec) error {
+	// index:model pairs, e.g. "0:qemu-xhci,1:piix3-uhci"
+	var warnings hookutil.Warnings
+	controllers := hookutil.ParseAliasValues(annotations[usbControllers], &warnings)
+	keys := make([]string, 0, len(controllers))
+	for key := range controllers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	models := make(map[uint64]string, len(controllers))
+	indexes := make([]uint64, 0, len(controllers))
+	for _, indexStr := range keys {
+		model := controllers[indexStr]
+		index, err := strconv.ParseUint(indexStr, 10, 8)
+		if err != nil || !usbControllerModels[model] {
+			warnings.Addf("Unsupported USB controller: %s:%s", indexStr, model)
+			continue
+		}
+		models[index] = model
+		indexes = append(indexes, index)
+	}
+	// add new controllers by index, "10" sorts before "2" as a string
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	for _, index := range indexes {
+		setUSBControllerModel(domainSpec, strconv.FormatUint(index, 10), models[index], true)
+	}
+
+	if portsStr, found := annotations[usbPorts]; found {
+		ports, err := strconv.ParseUint(portsStr, 10, 8)
+		if err != nil || ports < 1 || ports > maxXHCIPorts {
+			warnings.Addf("Invalid USB port count: %s", portsStr)
+			return warnings.Err()
+		}
+		// domain schema has no controller ports, set them for every qemu-xhci controller
+		hookutil.AppendQEMUArgs(domainSpec,
+			"-global", fmt.Sprintf("qemu-xhci.p2=%d", ports),
+			"-global", fmt.Sprintf("qemu-xhci.p3=%d", ports),
+		)
+	}
+	return warnings.Err()
+}
+
+// setUSBControllerModel reconciles the usb controller at index, dropping duplicates of it.
+// An existing controller keeps its model unless it is "none" or override is set.
+func setUSBControllerModel(domainSpec *domainSchema.DomainSpec, index string, model string, override bool) {
+	found := false
+	controllers := make([]domainSchema.Controller, 0, len(domainSpec.Devices.Controllers)+1)
+	for _, ctrl := range domainSpec.Devices.Controllers {
+		if ctrl.Type == "usb" && ctrl.Index == index {
+			if found {
+				log.Log.Infof("Drop duplicated usb controller with index %s", index)
+				continue
+			}
+			found = true
+			if ctrl.Model == "none" || override {
+				ctrl.Model = model
+			}
+		}
+		controllers = append(controllers, ctrl)
+	}
+
+	if !found {
+		controllers = append(controllers, domainSchema.Controller{
+			Type:  "usb",
+			Index: index,
+			Model: model,
+		})
+	}
+	domainSpec.Devices.Controllers = controllers
+}
+
+func validateControllers(domainSpec *domainSchema.DomainSpec) error {
+	seen := make(map[string]bool)
+	usbCount, usbNone := 0, false
+	for _, ctrl := range domainSpec.Devices.Controllers {
+		key := ctrl.Type + "/" + ctrl.Index
+		if seen[key] {
+			return fmt.Errorf("duplicated %s controller with index %s", ctrl.Type, ctrl.Index)
+		}
+		seen[key] = true
+
+		if ctrl.Type == "usb" {
+			usbCount++
+			usbNone = usbNone || ctrl.Model == "none"
+		}
+	}
+
+	if usbNone && usbCount > 1 {
+		return fmt.Errorf("usb controller with model none can not coexist with other usb controllers")
+	}
+	return nil
+}
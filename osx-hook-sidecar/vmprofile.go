@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+)
+
+// VM profiles mounted into the sidecar from a ConfigMap, one <name>.json file per profile
+var profileDirectory = "/etc/osx-hook-sidecar/profiles"
+
+var profileNameFormat = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// applyVMProfile returns the annotations of the profile the VMI names,
+// overridden by the annotations of the VMI itself
+func applyVMProfile(annotations map[string]string) (map[string]string, error) {
+	name, found := annotations[profileName]
+	if !found {
+		return annotations, nil
+	}
+	if !profileNameFormat.MatchString(name) {
+		return nil, fmt.Errorf("invalid profile name: %s", name)
+	}
+
+	path := filepath.Join(profileDirectory, name+".json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile %s: %v", name, err)
+	}
+	profile := make(map[string]string)
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %v", path, err)
+	}
+
+	merged := make(map[string]string, len(profile)+len(annotations))
+	for key, value := range profile {
+		merged[key] = value
+	}
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	return merged, nil
+}